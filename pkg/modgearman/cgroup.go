@@ -0,0 +1,24 @@
+package modgearman
+
+import "fmt"
+
+// cgroup modes, either one cgroup per plugin or one per worker pool
+const (
+	cgroupModePlugin = "plugin"
+	cgroupModePool   = "pool"
+)
+
+// checkCgroupConfig verifies the cgroup settings
+func checkCgroupConfig(config *config) error {
+	switch config.cgroupMode {
+	case cgroupModePlugin, cgroupModePool:
+	default:
+		return fmt.Errorf("unknown cgroup_mode: %s", config.cgroupMode)
+	}
+
+	if config.cgroupPidsMax < 0 {
+		return fmt.Errorf("cgroup_pids_max must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package modgearman
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroup2SuperMagic is the filesystem type of a cgroup v2 mount
+const cgroup2SuperMagic = 0x63677270
+
+var (
+	// cgroupBase is the cgroup used as parent for all plugin cgroups, empty if disabled
+	cgroupBase string
+
+	// cgroupCounter is used to create unique plugin cgroup names
+	cgroupCounter atomic.Int64
+
+	// cgroupPools contains all pool cgroups which have been prepared already
+	cgroupPools     = map[string]bool{}
+	cgroupPoolsLock sync.Mutex
+)
+
+// pluginCgroup is the cgroup a single plugin is executed in
+type pluginCgroup struct {
+	path     string
+	dir      *os.File
+	pool     string // name of the worker pool in cgroup_mode=pool, empty for plugin cgroups
+	remove   bool   // plugin cgroups are removed after execution, pool cgroups are kept
+	oomKills int64  // oom_kill counter before the plugin has been started
+}
+
+// initializeCgroups prepares the cgroup base folder, cgroup isolation will be disabled on any error
+func initializeCgroups(config *config) {
+	cgroupBase = ""
+	if config.cgroupPath == "" {
+		return
+	}
+
+	if err := setupCgroupBase(config); err != nil {
+		log.Warnf("cgroup isolation disabled: %s", err.Error())
+
+		return
+	}
+	cgroupBase = config.cgroupPath
+	log.Debugf("cgroup isolation enabled in %s (mode: %s)", cgroupBase, config.cgroupMode)
+}
+
+func setupCgroupBase(config *config) error {
+	var stat syscall.Statfs_t
+	parent := filepath.Dir(config.cgroupPath)
+	if err := syscall.Statfs(parent, &stat); err != nil {
+		return fmt.Errorf("cannot access %s: %s", parent, err.Error())
+	}
+	if stat.Type != cgroup2SuperMagic {
+		return fmt.Errorf("%s is not a cgroup v2 filesystem", parent)
+	}
+
+	if err := os.Mkdir(config.cgroupPath, 0o755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("cannot create cgroup %s: %s", config.cgroupPath, err.Error())
+	}
+
+	available, err := os.ReadFile(filepath.Join(config.cgroupPath, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cannot read cgroup controllers: %s", err.Error())
+	}
+
+	enable := []string{}
+	for _, controller := range strings.Fields(string(available)) {
+		switch controller {
+		case "memory", "cpu", "pids":
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return fmt.Errorf("none of the memory, cpu or pids controllers are available in %s", config.cgroupPath)
+	}
+
+	return writeCgroupFile(config.cgroupPath, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+// terminateCgroups removes all pool cgroups
+func terminateCgroups() {
+	cgroupPoolsLock.Lock()
+	defer cgroupPoolsLock.Unlock()
+
+	for path := range cgroupPools {
+		logDebug(os.Remove(path))
+	}
+	cgroupPools = map[string]bool{}
+}
+
+// newPluginCgroup returns the cgroup for the next plugin or nil if cgroup isolation is disabled
+func newPluginCgroup(config *config, pool string) *pluginCgroup {
+	if cgroupBase == "" {
+		return nil
+	}
+
+	cgroup := &pluginCgroup{}
+	switch config.cgroupMode {
+	case cgroupModePool:
+		cgroup.pool = pool
+		cgroup.path = filepath.Join(cgroupBase, "pool-"+pool)
+		if err := preparePoolCgroup(config, cgroup.path); err != nil {
+			log.Warnf("cannot create cgroup: %s", err.Error())
+
+			return nil
+		}
+	default:
+		cgroup.path = filepath.Join(cgroupBase, fmt.Sprintf("plugin-%d", cgroupCounter.Add(1)))
+		cgroup.remove = true
+		if err := createCgroup(config, cgroup.path); err != nil {
+			log.Warnf("cannot create cgroup: %s", err.Error())
+
+			return nil
+		}
+	}
+
+	dir, err := os.Open(cgroup.path)
+	if err != nil {
+		log.Warnf("cannot open cgroup: %s", err.Error())
+		cgroup.close()
+
+		return nil
+	}
+	cgroup.dir = dir
+	cgroup.oomKills = readCgroupOOMKills(cgroup.path)
+
+	return cgroup
+}
+
+func preparePoolCgroup(config *config, path string) error {
+	cgroupPoolsLock.Lock()
+	defer cgroupPoolsLock.Unlock()
+
+	if cgroupPools[path] {
+		return nil
+	}
+	if err := createCgroup(config, path); err != nil {
+		return err
+	}
+	cgroupPools[path] = true
+
+	return nil
+}
+
+// createCgroup creates the cgroup folder and sets all configured limits
+func createCgroup(config *config, path string) error {
+	if err := os.Mkdir(path, 0o755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("cannot create cgroup %s: %s", path, err.Error())
+	}
+
+	limits := map[string]string{
+		"memory.max": config.cgroupMemoryMax,
+		"cpu.max":    config.cgroupCPUMax,
+	}
+	if config.cgroupPidsMax > 0 {
+		limits["pids.max"] = strconv.Itoa(config.cgroupPidsMax)
+	}
+	for file, value := range limits {
+		if value == "" {
+			continue
+		}
+		if err := writeCgroupFile(path, file, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply places the command into this cgroup on start
+func (cg *pluginCgroup) apply(cmd *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// oomKilled returns true if the OOM killer has been active in the plugin cgroup since the plugin has been
+// started. Pool cgroups are shared by all plugins of the pool, so they never claim the plugin has been killed.
+func (cg *pluginCgroup) oomKilled() bool {
+	if cg == nil || cg.pool != "" {
+		return false
+	}
+
+	return readCgroupOOMKills(cg.path) > cg.oomKills
+}
+
+// poolOOMEvent returns true if the OOM killer has been active in the pool cgroup while the plugin was running,
+// the killed process might belong to any plugin of this pool
+func (cg *pluginCgroup) poolOOMEvent() bool {
+	if cg == nil || cg.pool == "" {
+		return false
+	}
+
+	return readCgroupOOMKills(cg.path) > cg.oomKills
+}
+
+// close releases the cgroup and removes plugin cgroups including all remaining processes
+func (cg *pluginCgroup) close() {
+	if cg == nil {
+		return
+	}
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	if !cg.remove {
+		return
+	}
+
+	go func(path string) {
+		defer logPanicExit()
+
+		// kill left over background processes, requires kernel 5.14
+		logTrace(writeCgroupFile(path, "cgroup.kill", "1"))
+		for range 10 {
+			if err := os.Remove(path); err == nil || os.IsNotExist(err) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		log.Debugf("cannot remove cgroup %s", path)
+	}(cg.path)
+}
+
+// readCgroupOOMKills returns the oom_kill counter from memory.events
+func readCgroupOOMKills(path string) int64 {
+	file, err := os.Open(filepath.Join(path, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			num, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+
+			return num
+		}
+	}
+
+	return 0
+}
+
+func writeCgroupFile(path, file, value string) error {
+	err := os.WriteFile(filepath.Join(path, file), []byte(value), 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot set %s in %s: %s", file, path, err.Error())
+	}
+
+	return nil
+}
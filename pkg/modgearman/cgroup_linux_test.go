@@ -0,0 +1,87 @@
+package modgearman
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupDisabledWithoutCgroupFS(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.cgroupPath = filepath.Join(t.TempDir(), "plugins")
+	cfg.cgroupMemoryMax = "64M"
+
+	initializeCgroups(&cfg)
+	defer terminateCgroups()
+	assert.Empty(t, cgroupBase)
+	assert.Nil(t, newPluginCgroup(&cfg, "default"))
+	assert.NoDirExists(t, cfg.cgroupPath)
+
+	// plugins run without isolation
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/echo ok", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, "ok", result.output)
+}
+
+func TestCgroupOOMKilled(t *testing.T) {
+	path := t.TempDir()
+	cgroup := &pluginCgroup{path: path}
+	assert.False(t, cgroup.oomKilled())
+
+	require.NoError(t, os.WriteFile(filepath.Join(path, "memory.events"),
+		[]byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\n"), 0o644))
+	assert.Equal(t, int64(1), readCgroupOOMKills(path))
+	assert.True(t, cgroup.oomKilled())
+
+	cgroup.oomKills = 1
+	assert.False(t, cgroup.oomKilled())
+
+	var nilCgroup *pluginCgroup
+	assert.False(t, nilCgroup.oomKilled())
+	assert.False(t, nilCgroup.poolOOMEvent())
+	assert.False(t, cgroup.poolOOMEvent(), "plugin cgroup")
+
+	// the oom_kill counter of pool cgroups belongs to all plugins of the pool
+	poolCgroup := &pluginCgroup{path: path, pool: "default"}
+	assert.False(t, poolCgroup.oomKilled())
+	assert.True(t, poolCgroup.poolOOMEvent())
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.cgroupMemoryMax = "64M"
+	result := &answer{returnCode: -1, output: "partial output"}
+	setOOMKilledResult(result, &cfg, &request{typ: "service", hostName: "testhost", commandLine: "/bin/true"})
+	assert.Equal(t, 3, result.returnCode)
+	assert.Equal(t, "UNKNOWN: Plugin has been killed by the OOM killer, cgroup memory limit of 64M exceeded. (worker: testworker)\n"+
+		"partial output", result.output)
+
+	result = &answer{returnCode: 2, output: "CRITICAL - failed"}
+	setPoolOOMEventResult(result, &cfg, &request{typ: "service", hostName: "testhost", commandLine: "/bin/true", pool: "default"})
+	assert.Equal(t, 2, result.returnCode)
+	assert.Equal(t, "CRITICAL - failed\n(pool OOM event: the OOM killer has been active in worker pool default, cgroup memory limit of 64M reached. (worker: testworker))",
+		result.output)
+}
+
+func TestCgroupIsolation(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.cgroupPath = os.Getenv("MOD_GEARMAN_TEST_CGROUP")
+	if cfg.cgroupPath == "" {
+		t.Skip("set MOD_GEARMAN_TEST_CGROUP to a delegated cgroup v2 path to run this test")
+	}
+	cfg.cgroupPidsMax = 10
+
+	initializeCgroups(&cfg)
+	defer terminateCgroups()
+	require.NotEmpty(t, cgroupBase)
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/cat /proc/self/cgroup", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Contains(t, result.output, "/plugin-")
+}
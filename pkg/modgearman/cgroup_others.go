@@ -0,0 +1,37 @@
+//go:build !linux
+
+package modgearman
+
+import "os/exec"
+
+type pluginCgroup struct{}
+
+func initializeCgroups(config *config) {
+	if config.cgroupPath != "" {
+		log.Warnf("cgroup isolation disabled: only supported on linux")
+	}
+}
+
+func terminateCgroups() {
+	// not supported
+}
+
+func newPluginCgroup(_ *config, _ string) *pluginCgroup {
+	return nil
+}
+
+func (cg *pluginCgroup) apply(_ *exec.Cmd) {
+	// not supported
+}
+
+func (cg *pluginCgroup) oomKilled() bool {
+	return false
+}
+
+func (cg *pluginCgroup) poolOOMEvent() bool {
+	return false
+}
+
+func (cg *pluginCgroup) close() {
+	// not supported
+}
@@ -1,10 +1,12 @@
 package modgearman
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -177,6 +179,14 @@ func checkWorker(args *checkGmArgs) int {
 		return res.statusCode
 	}
 
+	// json responses from the status worker, depending on the requested status type
+	if statusCode, output, ok := formatStatusResponse(args.TextToSend, res.response); ok {
+		res.statusCode, res.response = statusCode, output
+		fmt.Fprintf(os.Stdout, "%s\n", res.response)
+
+		return res.statusCode
+	}
+
 	// If result starts with a number followed by a colon, use this as exit code
 	if len(res.response) > 1 && res.response[1] == ':' {
 		res.statusCode = int(res.response[0] - '0')
@@ -195,6 +205,80 @@ func checkWorker(args *checkGmArgs) int {
 	return res.statusCode
 }
 
+// formatStatusResponse returns exit code and output for json responses of the status worker, the
+// requested status type decides how the response is printed. ok is false for all other requests.
+func formatStatusResponse(request, response string) (statusCode int, output string, ok bool) {
+	switch statusRequestType(request) {
+	case "json":
+		summary := parseStatusSummary(response)
+		if summary == nil {
+			return stateUnknown, fmt.Sprintf("%s UNKNOWN - cannot parse status response: %s", pluginName, response), true
+		}
+		statusCode, output = formatStatusSummary(summary)
+
+		return statusCode, pluginName + " " + output, true
+	case "jobs", "config":
+		return stateOk, response, true
+	}
+
+	return stateOk, "", false
+}
+
+// parseStatusSummary returns the status summary if the response is a json status worker response
+func parseStatusSummary(response string) *statusSummary {
+	if !strings.HasPrefix(response, "{") {
+		return nil
+	}
+	summary := &statusSummary{}
+	if err := json.Unmarshal([]byte(response), summary); err != nil || summary.Identifier == "" {
+		return nil
+	}
+
+	return summary
+}
+
+// formatStatusSummary returns exit code and plugin output for a status summary
+func formatStatusSummary(summary *statusSummary) (statusCode int, output string) {
+	statusCode = stateOk
+	failed := []string{}
+	for _, server := range summary.Servers {
+		if !server.OK {
+			failed = append(failed, fmt.Sprintf("%s: %s", server.Address, server.Error))
+		}
+	}
+	if len(failed) > 0 {
+		statusCode = stateWarning
+	}
+
+	execs := make([]string, 0, len(summary.TasksByExec))
+	for exec := range summary.TasksByExec {
+		execs = append(execs, exec)
+	}
+	sort.Strings(execs)
+	perfdata := fmt.Sprintf("worker=%d;;;%d;%d busy=%d idle=%d ballooning=%d jobs=%dc",
+		summary.Workers, summary.MinWorker, summary.MaxWorker, summary.Busy, summary.Idle, summary.Ballooning, summary.Tasks)
+	for _, exec := range execs {
+		perfdata += fmt.Sprintf(" 'jobs_%s'=%dc", exec, summary.TasksByExec[exec])
+	}
+
+	output = fmt.Sprintf("%s - %s has %d worker (busy: %d, idle: %d, ballooning: %d) and is up since %s. Version: %s",
+		ternary(statusCode == stateOk, "OK", "WARNING"),
+		summary.Identifier,
+		summary.Workers,
+		summary.Busy,
+		summary.Idle,
+		summary.Ballooning,
+		time.Duration(summary.Uptime)*time.Second,
+		summary.Version,
+	)
+	output += "|" + perfdata
+	if len(failed) > 0 {
+		output += "\nfailed server: " + strings.Join(failed, ", ")
+	}
+
+	return statusCode, output
+}
+
 func createWorkerJob(args *checkGmArgs, res *responseData) (err error) {
 	// Unique id for all tasks is just "check" because it's the main task performed and helps with performance in naemon
 	client.IdGen = &checkGearmanIDGen{}
@@ -428,6 +512,10 @@ func printUsageCheckGearman(args *checkGmArgs) {
 	fmt.Fprintf(os.Stdout, "\n")
 	fmt.Fprintf(os.Stdout, "%%> ./check_gearman -H <job server hostname> -q worker_<worker hostname> -t 10 -s check\n")
 	fmt.Fprintf(os.Stdout, "check_gearman OK - host has 5 worker and is working on 0 jobs\n")
+	fmt.Fprintf(os.Stdout, "%%> ./check_gearman -H <job server hostname> -q worker_<worker hostname> -t 10 -s json\n")
+	fmt.Fprintf(os.Stdout, "check_gearman OK - host has 5 worker (busy: 1, idle: 4, ballooning: 0) and is up since 2h0m0s. "+
+		"Version: 1.7.0|worker=5;;;1;20 busy=1 idle=4 ballooning=0 jobs=1508c 'jobs_exec'=1508c\n")
+	fmt.Fprintf(os.Stdout, "  (-s jobs and -s config return the running jobs and the effective config as json)\n")
 	fmt.Fprintf(os.Stdout, "%%> ./check_gearman -H <job server hostname> -q perfdata -t 10 -x\n")
 	fmt.Fprintf(os.Stdout, "check_gearman CRITICAL - Queue perfdata has 155 jobs without any worker. "+
 		"|'perfdata_waiting'=155;10;100;0 'perfdata_running'=0 'perfdata_worker'=0;25;50;0\n")
@@ -30,6 +30,7 @@ type command struct {
 	Env           map[string]string
 	Negate        *Negate
 	InternalCheck InternalCheck
+	Sandbox       *sandboxProfile
 }
 
 func parseCommand(rawCommand string, config *config) *command {
@@ -38,6 +39,7 @@ func parseCommand(rawCommand string, config *config) *command {
 		Command:  rawCommand,
 		Args:     make([]string, 0),
 		Env:      make(map[string]string),
+		Sandbox:  config.sandboxProfile(rawCommand),
 	}
 
 	envs, args, err := shelltoken.SplitLinux(rawCommand)
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -18,6 +19,10 @@ type config struct {
 	debug                     int
 	logfile                   string
 	logmode                   string
+	logformat                 string
+	syslogFacility            string
+	syslogAddress             string
+	syslogTag                 string
 	dupserver                 []string
 	eventhandler              bool
 	notifications             bool
@@ -25,16 +30,24 @@ type config struct {
 	hosts                     bool
 	hostgroups                []string
 	servicegroups             []string
+	queueLimits               []string
+	workerPoolSettings        []string
+	pools                     []*workerPool
 	encryption                bool
 	key                       string
 	keyfile                   string
 	pidfile                   string
 	jobTimeout                int
+	jobsPerConnection         int
 	minWorker                 int
 	maxWorker                 int
 	numResultWorker           int
 	idleTimeout               int
+	queueScaling              bool
+	drainExit                 bool
+	queueScalingInterval      int
 	maxAge                    int
+	checkSmoothingWindow      int
 	spawnRate                 int
 	sinkRate                  int
 	loadLimit1                float64
@@ -42,15 +55,57 @@ type config struct {
 	loadLimit15               float64
 	loadCPUMulti              float64
 	memLimit                  uint64
+	psiLimits                 []string
+	psiLimitRules             []*psiLimit
 	backgroundingThreshold    int
 	showErrorOutput           bool
+	maxOutputSize             int
+	perfdataValidation        string
+	perfdataMetrics           bool
+	perfdataMetricsMaxSeries  int
+	perfdataMetricsMaxAge     int
+	perfdataMetricsAllow      []string
+	perfdataMetricsDeny       []string
+	perfdataMetricsAllowRe    []*regexp.Regexp
+	perfdataMetricsDenyRe     []*regexp.Regexp
+	perfdataForward           []string
+	perfdataForwardPrefix     string
+	perfdataForwardBatchSize  int
+	perfdataForwardBufferSize int
+	perfdataForwardInterval   int
 	dupResultsArePassive      bool
 	dupServerBacklogQueueSize int
+	dupServerBacklogDir       string
+	dupServerBacklogMaxSize   int64
+	dupServerBacklogMaxAge    int
+	dupServerBacklogOverflow  string
+	resultSpoolDir            string
+	resultSpoolMaxSize        int64
+	resultSpoolMaxAge         int
 	restrictPath              []string
+	rlimits                   []string
+	rlimitRules               []*rlimitRule
+	runAs                     []string
+	runAsRules                []*runAsRule
+	sandboxes                 []string
+	sandboxProfiles           []*sandboxProfile
+	resultCache               []string
+	resultCacheRules          []*resultCacheRule
+	cgroupPath                string
+	cgroupMode                string
+	cgroupMemoryMax           string
+	cgroupCPUMax              string
+	cgroupPidsMax             int
+	resultSinks               []string
+	traceExporter             string
+	traceSampleRatio          float64
 	server                    []string
 	timeoutReturn             int
+	cancelReturn              int
 	daemon                    bool
 	prometheusServer          string
+	statusServer              string
+	statusAdmin               bool
 	enableEmbeddedPerl        bool
 	useEmbeddedPerlImplicitly bool
 	usePerlCache              bool
@@ -85,15 +140,35 @@ type config struct {
 // setDefaultValues sets reasonable defaults
 func (config *config) setDefaultValues() {
 	config.logmode = "automatic"
+	config.logformat = "text"
+	config.syslogFacility = "daemon"
 	config.encryption = true
 	config.showErrorOutput = true
+	config.maxOutputSize = 1024 * 1024
+	config.perfdataValidation = perfdataValidationOff
+	config.perfdataMetricsMaxSeries = 10000
+	config.perfdataMetricsMaxAge = 3600
+	config.perfdataForwardPrefix = "perfdata"
+	config.perfdataForwardBatchSize = 500
+	config.perfdataForwardBufferSize = 10000
+	config.perfdataForwardInterval = 10
 	config.debug = 0
 	config.logmode = "automatic"
 	config.dupResultsArePassive = true
 	config.dupServerBacklogQueueSize = 1000
+	config.dupServerBacklogMaxSize = 100
+	config.dupServerBacklogMaxAge = 86400
+	config.dupServerBacklogOverflow = backlogOverflowDropNewest
+	config.resultSpoolMaxSize = 100
+	config.resultSpoolMaxAge = 3600
+	config.traceSampleRatio = 1
 	config.timeoutReturn = 3
+	config.cancelReturn = 3
+	config.cgroupMode = cgroupModePlugin
 	config.jobTimeout = 60
+	config.jobsPerConnection = 1
 	config.idleTimeout = 10
+	config.queueScalingInterval = 5
 	config.daemon = false
 	config.minWorker = 1
 	config.maxWorker = 20
@@ -131,7 +206,18 @@ func (config *config) cleanListAttributes() {
 	config.dupserver = cleanListAttribute(config.dupserver)
 	config.hostgroups = cleanListAttribute(config.hostgroups)
 	config.servicegroups = cleanListAttribute(config.servicegroups)
+	config.queueLimits = cleanListAttribute(config.queueLimits)
+	config.workerPoolSettings = cleanListAttribute(config.workerPoolSettings)
 	config.restrictPath = cleanListAttribute(config.restrictPath)
+	config.rlimits = cleanListAttribute(config.rlimits)
+	config.runAs = cleanListAttribute(config.runAs)
+	config.sandboxes = cleanListAttribute(config.sandboxes)
+	config.resultCache = cleanListAttribute(config.resultCache)
+	config.psiLimits = cleanListAttribute(config.psiLimits)
+	config.perfdataMetricsAllow = cleanListAttribute(config.perfdataMetricsAllow)
+	config.perfdataMetricsDeny = cleanListAttribute(config.perfdataMetricsDeny)
+	config.perfdataForward = cleanListAttribute(config.perfdataForward)
+	config.resultSinks = cleanListAttribute(config.resultSinks)
 }
 
 // dump logs all config items
@@ -142,6 +228,10 @@ func (config *config) dump() {
 	log.Debugf("debug                         %d\n", config.debug)
 	log.Debugf("logfile                       %s\n", config.logfile)
 	log.Debugf("logmode                       %s\n", config.logmode)
+	log.Debugf("logformat                     %s\n", config.logformat)
+	log.Debugf("syslog_facility               %s\n", config.syslogFacility)
+	log.Debugf("syslog_address                %s\n", config.syslogAddress)
+	log.Debugf("syslog_tag                    %s\n", config.syslogTag)
 	log.Debugf("server                        %v\n", config.server)
 	log.Debugf("dupserver                     %v\n", config.dupserver)
 	log.Debugf("eventhandler                  %v\n", config.eventhandler)
@@ -150,15 +240,22 @@ func (config *config) dump() {
 	log.Debugf("hosts                         %v\n", config.hosts)
 	log.Debugf("hostgroups                    %v\n", config.hostgroups)
 	log.Debugf("servicegroups                 %v\n", config.servicegroups)
+	log.Debugf("queue_limit                   %v\n", config.queueLimits)
+	log.Debugf("pools                         %v\n", config.workerPoolSettings)
 	log.Debugf("encryption                    %v\n", config.encryption)
 	log.Debugf("keyfile                       %s\n", config.keyfile)
 	log.Debugf("pidfile                       %s\n", config.pidfile)
 	log.Debugf("jobTimeout                    %ds\n", config.jobTimeout)
+	log.Debugf("jobsPerConnection             %d\n", config.jobsPerConnection)
 	log.Debugf("minWorker                     %d\n", config.minWorker)
 	log.Debugf("maxWorker                     %d\n", config.maxWorker)
 	log.Debugf("numResultWorker               %d\n", config.numResultWorker)
 	log.Debugf("idleTimeout                   %ds\n", config.idleTimeout)
+	log.Debugf("queueScaling                  %v\n", config.queueScaling)
+	log.Debugf("drainExit                     %v\n", config.drainExit)
+	log.Debugf("queueScalingInterval          %ds\n", config.queueScalingInterval)
 	log.Debugf("maxAge                        %d\n", config.maxAge)
+	log.Debugf("checkSmoothingWindow          %ds\n", config.checkSmoothingWindow)
 	log.Debugf("spawnRate                     %d/s\n", config.spawnRate)
 	log.Debugf("sinkRate                      %d/s\n", config.sinkRate)
 	log.Debugf("loadLimit1                    %.2f\n", config.loadLimit1)
@@ -166,14 +263,49 @@ func (config *config) dump() {
 	log.Debugf("loadLimit15                   %.2f\n", config.loadLimit15)
 	log.Debugf("loadCPUMulti                  %.2f\n", config.loadCPUMulti)
 	log.Debugf("memLimit                      %d%%\n", config.memLimit)
+	log.Debugf("psi_limit                     %v\n", config.psiLimits)
 	log.Debugf("backgroundingThreshold        %ds\n", config.backgroundingThreshold)
 	log.Debugf("showErrorOutput               %v\n", config.showErrorOutput)
+	log.Debugf("maxOutputSize                 %d\n", config.maxOutputSize)
+	log.Debugf("perfdataValidation            %s\n", config.perfdataValidation)
+	log.Debugf("perfdataMetrics               %v\n", config.perfdataMetrics)
+	log.Debugf("perfdataMetricsMaxSeries      %d\n", config.perfdataMetricsMaxSeries)
+	log.Debugf("perfdataMetricsMaxAge         %ds\n", config.perfdataMetricsMaxAge)
+	log.Debugf("perfdataMetricsAllow          %v\n", config.perfdataMetricsAllow)
+	log.Debugf("perfdataMetricsDeny           %v\n", config.perfdataMetricsDeny)
+	log.Debugf("perfdataForward               %v\n", config.perfdataForward)
+	log.Debugf("perfdataForwardPrefix         %s\n", config.perfdataForwardPrefix)
+	log.Debugf("perfdataForwardBatchSize      %d\n", config.perfdataForwardBatchSize)
+	log.Debugf("perfdataForwardBufferSize     %d\n", config.perfdataForwardBufferSize)
+	log.Debugf("perfdataForwardInterval       %ds\n", config.perfdataForwardInterval)
 	log.Debugf("dupResultsArePassive          %v\n", config.dupResultsArePassive)
 	log.Debugf("dupServerBacklogQueueSize     %d\n", config.dupServerBacklogQueueSize)
+	log.Debugf("dupServerBacklogDir           %s\n", config.dupServerBacklogDir)
+	log.Debugf("dupServerBacklogMaxSize       %dMB\n", config.dupServerBacklogMaxSize)
+	log.Debugf("dupServerBacklogMaxAge        %ds\n", config.dupServerBacklogMaxAge)
+	log.Debugf("dupServerBacklogOverflow      %s\n", config.dupServerBacklogOverflow)
+	log.Debugf("resultSpoolDir                %s\n", config.resultSpoolDir)
+	log.Debugf("resultSpoolMaxSize            %dMB\n", config.resultSpoolMaxSize)
+	log.Debugf("resultSpoolMaxAge             %ds\n", config.resultSpoolMaxAge)
 	log.Debugf("restrictPath                  %v\n", config.restrictPath)
+	log.Debugf("rlimit                        %v\n", config.rlimits)
+	log.Debugf("run_as                        %v\n", config.runAs)
+	log.Debugf("sandbox                       %v\n", config.sandboxes)
+	log.Debugf("result_cache                  %v\n", config.resultCache)
+	log.Debugf("cgroup_path                   %s\n", config.cgroupPath)
+	log.Debugf("cgroup_mode                   %s\n", config.cgroupMode)
+	log.Debugf("cgroup_memory_max             %s\n", config.cgroupMemoryMax)
+	log.Debugf("cgroup_cpu_max                %s\n", config.cgroupCPUMax)
+	log.Debugf("cgroup_pids_max               %d\n", config.cgroupPidsMax)
+	log.Debugf("resultSinks                   %v\n", config.resultSinks)
+	log.Debugf("traceExporter                 %s\n", config.traceExporter)
+	log.Debugf("traceSampleRatio              %f\n", config.traceSampleRatio)
 	log.Debugf("timeoutReturn                 %d\n", config.timeoutReturn)
+	log.Debugf("cancelReturn                  %d\n", config.cancelReturn)
 	log.Debugf("daemon                        %v\n", config.daemon)
 	log.Debugf("prometheusServer              %s\n", config.prometheusServer)
+	log.Debugf("statusServer                  %s\n", config.statusServer)
+	log.Debugf("statusAdmin                   %v\n", config.statusAdmin)
 	log.Debugf("enableEmbeddedPerl            %v\n", config.enableEmbeddedPerl)
 	log.Debugf("useEmbeddedPerlImplicitly     %v\n", config.useEmbeddedPerlImplicitly)
 	log.Debugf("usePerlCache                  %v\n", config.usePerlCache)
@@ -216,6 +348,8 @@ func (config *config) parseConfigItem(raw string) error {
 			list[i] = strings.Trim(el, " ")
 		}
 		config.servicegroups = append(config.servicegroups, list...)
+	case "queue_limit":
+		config.queueLimits = append(config.queueLimits, value)
 	case "server":
 		list := strings.Split(value, ",")
 		for i, el := range list {
@@ -227,8 +361,14 @@ func (config *config) parseConfigItem(raw string) error {
 		config.server = append(config.server, list...)
 	case "prometheus_server":
 		config.prometheusServer = value
+	case "status_server":
+		config.statusServer = value
+	case "status_admin":
+		config.statusAdmin = getBool(value)
 	case "timeout_return":
 		config.timeoutReturn = getInt(value)
+	case "cancel_return":
+		config.cancelReturn = getInt(value)
 	case "config":
 		err := config.readSettingsPath(value)
 		if err != nil {
@@ -246,6 +386,18 @@ func (config *config) parseConfigItem(raw string) error {
 	case "logmode":
 		config.logmode = value
 		createLogger(config)
+	case "logformat":
+		config.logformat = strings.ToLower(value)
+		createLogger(config)
+	case "syslog_facility":
+		config.syslogFacility = strings.ToLower(value)
+		createLogger(config)
+	case "syslog_address":
+		config.syslogAddress = value
+		createLogger(config)
+	case "syslog_tag":
+		config.syslogTag = value
+		createLogger(config)
 	case "identifier":
 		config.identifier = value
 	case "eventhandler":
@@ -266,6 +418,8 @@ func (config *config) parseConfigItem(raw string) error {
 		config.pidfile = value
 	case "job_timeout":
 		config.jobTimeout = getInt(value)
+	case "jobs_per_connection":
+		config.jobsPerConnection = getInt(value)
 	case "min-worker":
 		config.minWorker = getInt(value)
 	case "max-worker":
@@ -274,8 +428,16 @@ func (config *config) parseConfigItem(raw string) error {
 		config.numResultWorker = getInt(value)
 	case "idle-timeout":
 		config.idleTimeout = getInt(value)
+	case "queue_scaling":
+		config.queueScaling = getBool(value)
+	case "drain_exit":
+		config.drainExit = getBool(value)
+	case "queue_scaling_interval":
+		config.queueScalingInterval = getInt(value)
 	case "max-age":
 		config.maxAge = getInt(value)
+	case "check_smoothing_window":
+		config.checkSmoothingWindow = getInt(value)
 	case "spawn-rate":
 		config.spawnRate = getInt(value)
 	case "sink-rate":
@@ -290,20 +452,84 @@ func (config *config) parseConfigItem(raw string) error {
 		config.loadCPUMulti = getFloat(value)
 	case "mem_limit":
 		config.memLimit = uint64(getFloat(value))
+	case "psi_limit":
+		config.psiLimits = append(config.psiLimits, value)
 	case "backgrounding-threshold":
 		config.backgroundingThreshold = getInt(value)
 	case "show_error_output":
 		config.showErrorOutput = getBool(value)
+	case "max_output_size":
+		config.maxOutputSize = getInt(value)
+	case "perfdata_validation":
+		config.perfdataValidation = strings.ToLower(value)
+	case "perfdata_metrics":
+		config.perfdataMetrics = getBool(value)
+	case "perfdata_metrics_max_series":
+		config.perfdataMetricsMaxSeries = getInt(value)
+	case "perfdata_metrics_max_age":
+		config.perfdataMetricsMaxAge = getInt(value)
+	case "perfdata_metrics_allow":
+		config.perfdataMetricsAllow = append(config.perfdataMetricsAllow, value)
+	case "perfdata_metrics_deny":
+		config.perfdataMetricsDeny = append(config.perfdataMetricsDeny, value)
+	case "perfdata_forward":
+		config.perfdataForward = append(config.perfdataForward, value)
+	case "perfdata_forward_prefix":
+		config.perfdataForwardPrefix = value
+	case "perfdata_forward_batch_size":
+		config.perfdataForwardBatchSize = getInt(value)
+	case "perfdata_forward_buffer_size":
+		config.perfdataForwardBufferSize = getInt(value)
+	case "perfdata_forward_flush_interval":
+		config.perfdataForwardInterval = getInt(value)
 	case "dup_results_are_passive":
 		config.dupResultsArePassive = getBool(value)
 	case "dupserver_backlog_queue_size":
 		config.dupServerBacklogQueueSize = getInt(value)
+	case "dupserver_backlog_dir":
+		config.dupServerBacklogDir = value
+	case "dupserver_backlog_max_size":
+		config.dupServerBacklogMaxSize = int64(getInt(value))
+	case "dupserver_backlog_max_age":
+		config.dupServerBacklogMaxAge = getInt(value)
+	case "dupserver_backlog_overflow":
+		config.dupServerBacklogOverflow = strings.ToLower(value)
+	case "result_spool_dir":
+		config.resultSpoolDir = value
+	case "result_spool_max_size":
+		config.resultSpoolMaxSize = int64(getInt(value))
+	case "result_spool_max_age":
+		config.resultSpoolMaxAge = getInt(value)
 	case "gearman_connection_timeout":
 		// unused, timeout is not exposed by libworker
 	case "max-jobs":
 		// unused
 	case "restrict_path":
 		config.restrictPath = append(config.restrictPath, value)
+	case "rlimit":
+		config.rlimits = append(config.rlimits, value)
+	case "run_as":
+		config.runAs = append(config.runAs, value)
+	case "sandbox":
+		config.sandboxes = append(config.sandboxes, value)
+	case "result_cache":
+		config.resultCache = append(config.resultCache, value)
+	case "cgroup_path":
+		config.cgroupPath = value
+	case "cgroup_mode":
+		config.cgroupMode = strings.ToLower(value)
+	case "cgroup_memory_max":
+		config.cgroupMemoryMax = value
+	case "cgroup_cpu_max":
+		config.cgroupCPUMax = value
+	case "cgroup_pids_max":
+		config.cgroupPidsMax = getInt(value)
+	case "result_sink":
+		config.resultSinks = append(config.resultSinks, value)
+	case "trace_exporter":
+		config.traceExporter = value
+	case "trace_sample_ratio":
+		config.traceSampleRatio = getFloat(value)
 	case "timeout", "t":
 		config.timeout = getFloat(value)
 	case "delimiter", "d":
@@ -359,6 +585,12 @@ func (config *config) parseConfigItem(raw string) error {
 	case "retry-interval":
 		config.sendRetryInterval = getFloat(value)
 	default:
+		// named worker pools: pool.<name>.<option>=<value>
+		if pool, ok := strings.CutPrefix(key, "pool."); ok {
+			config.workerPoolSettings = append(config.workerPoolSettings, pool+"="+value)
+
+			break
+		}
 		log.Warnf("unknown configuration option: %s", raw)
 	}
 
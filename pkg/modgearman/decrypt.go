@@ -2,12 +2,15 @@ package modgearman
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	b64 "encoding/base64"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var myCipher cipher.Block
@@ -23,10 +26,20 @@ type request struct {
 	coreTime           float64
 	timeout            int
 	commandLine        string
-	ballooning         bool   // flag wether this job has been put into background
-	Cancel             func() // cancel current job
-	Canceled           bool
+	ballooning         bool   // flag wether this job has been put into background, guarded by the worker lock
+	cancel             func() // cancel current job, guarded by cancelLock
+	canceled           bool   // guarded by cancelLock
+	adminCanceled      bool   // flag wether this job has been canceled by the admin api, guarded by cancelLock
+	delayed            bool   // flag wether this job waits for check smoothing, guarded by cancelLock
+	handle             string // gearman job handle
+	pool               string // name of the worker pool executing this job
+	queue              string // gearman queue this job has been received from
 	rawRequest         []byte
+	receivedAt         time.Time       // time the worker received this job
+	traceParent        string          // w3c trace context passed in by the core
+	traceState         string          // w3c trace state passed in by the core
+	traceCtx           context.Context // context of the current job span
+	cancelLock         sync.Mutex
 }
 
 func (r *request) String() string {
@@ -54,6 +67,77 @@ func (r *request) String() string {
 	)
 }
 
+// setCancel sets the function which cancels the currently running job, nil if the job cannot be canceled (anymore)
+func (r *request) setCancel(cancel func()) {
+	r.cancelLock.Lock()
+	r.cancel = cancel
+	r.cancelLock.Unlock()
+}
+
+// cancelable returns true if the job can be canceled currently
+func (r *request) cancelable() bool {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	return r.cancel != nil
+}
+
+// Cancel cancels the running job, admin canceled jobs still send back a result.
+// Returns false if the job cannot be canceled.
+func (r *request) Cancel(admin bool) bool {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	if r.cancel == nil {
+		return false
+	}
+	r.canceled = true
+	if admin {
+		r.adminCanceled = true
+	}
+	r.cancel()
+
+	return true
+}
+
+// isCanceled returns true if the job has been canceled and no result must be sent
+func (r *request) isCanceled() bool {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	return r.canceled
+}
+
+// setCanceled changes the canceled flag, ex.: to send back the result of admin canceled jobs
+func (r *request) setCanceled(canceled bool) {
+	r.cancelLock.Lock()
+	r.canceled = canceled
+	r.cancelLock.Unlock()
+}
+
+// isAdminCanceled returns true if the job has been canceled by the admin api
+func (r *request) isAdminCanceled() bool {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	return r.adminCanceled
+}
+
+// setDelayed marks the job as waiting for check smoothing
+func (r *request) setDelayed(delayed bool) {
+	r.cancelLock.Lock()
+	r.delayed = delayed
+	r.cancelLock.Unlock()
+}
+
+// isDelayed returns true while the job waits for check smoothing
+func (r *request) isDelayed() bool {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	return r.delayed
+}
+
 func createCipher(key []byte, encrypt bool) cipher.Block {
 	if encrypt {
 		newCipher, err := aes.NewCipher(key)
@@ -147,7 +231,8 @@ func createReceived(input []byte) (*request, error) {
 	result.coreTime = parseTimeStringToFloat64(stringMap["core_time"])
 	result.nextCheck = parseTimeStringToFloat64(stringMap["next_check"])
 	result.timeout = getInt(stringMap["timeout"])
-	result.Cancel = nil
+	result.traceParent = stringMap["traceparent"]
+	result.traceState = stringMap["tracestate"]
 
 	return &result, nil
 }
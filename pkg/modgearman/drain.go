@@ -0,0 +1,176 @@
+package modgearman
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// drainCheckInterval sets how often the drain progress is checked
+	drainCheckInterval = 1 * time.Second
+
+	// drainLogInterval sets how often the drain progress is logged
+	drainLogInterval = 10 * time.Second
+)
+
+// drainState tracks the drain mode of the main worker
+type drainState struct {
+	lock       sync.Mutex
+	active     bool
+	exit       bool // quit once all jobs are finished and results are flushed
+	finished   bool
+	since      time.Time
+	generation int // changes on every drain and undrain to stop outdated drain routines
+}
+
+// drainProgress contains everything the drain is still waiting for
+type drainProgress struct {
+	Workers        int `json:"workers"`
+	RunningJobs    int `json:"running_jobs"`
+	BallooningJobs int `json:"ballooning_jobs"`
+	PendingResults int `json:"pending_results"`
+}
+
+func (p *drainProgress) done() bool {
+	return p.Workers == 0 && p.RunningJobs == 0 && p.BallooningJobs == 0 && p.PendingResults == 0
+}
+
+// startDrain stops fetching new jobs and waits for all running jobs to finish and all results
+// being sent. The worker quits afterwards if exit is set, otherwise it stays idle until stopDrain.
+// Returns false if the worker is draining already, only the exit flag is updated then.
+func (w *mainWorker) startDrain(exit bool) bool {
+	w.drain.lock.Lock()
+	defer w.drain.lock.Unlock()
+
+	w.drain.exit = exit
+	if w.drain.active {
+		return false
+	}
+	w.drain.active = true
+	w.drain.finished = false
+	w.drain.since = time.Now()
+	w.drain.generation++
+	drainingGauge.Set(1)
+
+	log.Infof("drain: stop fetching new jobs, waiting for running jobs to finish (exit afterwards: %v)", exit)
+	go w.runDrain(w.drain.generation)
+
+	return true
+}
+
+// stopDrain leaves the drain mode, new workers will be started again by manageWorkers.
+// Returns false if the worker is not draining.
+func (w *mainWorker) stopDrain() bool {
+	w.drain.lock.Lock()
+	defer w.drain.lock.Unlock()
+
+	if !w.drain.active {
+		return false
+	}
+	w.drain.active = false
+	w.drain.finished = false
+	w.drain.generation++
+	drainingGauge.Set(0)
+	log.Infof("drain: canceled, resume fetching new jobs")
+
+	return true
+}
+
+// isDraining returns true if no new workers must be started
+func (w *mainWorker) isDraining() bool {
+	w.drain.lock.Lock()
+	defer w.drain.lock.Unlock()
+
+	return w.drain.active
+}
+
+func (w *mainWorker) drainGeneration() int {
+	w.drain.lock.Lock()
+	defer w.drain.lock.Unlock()
+
+	return w.drain.generation
+}
+
+// runDrain stops all check worker and waits till everything is finished
+func (w *mainWorker) runDrain(generation int) {
+	defer logPanicExit()
+
+	// stopping a worker blocks until its current jobs are finished
+	for _, wrk := range w.listWorkers() {
+		go func() {
+			defer logPanicExit()
+			wrk.Shutdown()
+		}()
+	}
+
+	lastLog := time.Now()
+	for {
+		time.Sleep(drainCheckInterval)
+		if w.drainGeneration() != generation {
+			return
+		}
+
+		progress := w.drainProgress()
+		if progress.done() {
+			break
+		}
+		if time.Since(lastLog) >= drainLogInterval {
+			log.Infof("drain: waiting for %d worker, %d running jobs, %d ballooning jobs and %d pending results",
+				progress.Workers, progress.RunningJobs, progress.BallooningJobs, progress.PendingResults)
+			lastLog = time.Now()
+		}
+	}
+
+	w.drain.lock.Lock()
+	if w.drain.generation != generation {
+		w.drain.lock.Unlock()
+
+		return
+	}
+	w.drain.finished = true
+	exit := w.drain.exit
+	since := w.drain.since
+	w.drain.lock.Unlock()
+
+	if !exit {
+		log.Infof("drain: finished after %s, staying idle until undrain", time.Since(since).Truncate(time.Second))
+
+		return
+	}
+	log.Infof("drain: finished after %s, quitting", time.Since(since).Truncate(time.Second))
+	select {
+	case w.drainExit <- true:
+	default:
+	}
+}
+
+// drainProgress returns the number of remaining worker, jobs and results
+func (w *mainWorker) drainProgress() *drainProgress {
+	progress := &drainProgress{
+		BallooningJobs: w.numBallooningWorker(),
+		PendingResults: pendingResults(),
+	}
+	for _, wrk := range w.listWorkers() {
+		progress.Workers++
+		progress.RunningJobs += wrk.numActiveJobs()
+	}
+
+	return progress
+}
+
+// pendingResults returns the number of results not yet sent to the result, dup servers and sinks.
+// Results in the persistent spool and backlog are not counted, they will be sent after the restart.
+func pendingResults() int {
+	pending := len(resultServerQueue)
+	for _, consumer := range dupServerConsumers {
+		pending += len(consumer.queue)
+	}
+	for _, consumer := range resultSinkConsumers {
+		pending += len(consumer.queue)
+	}
+	for _, forwarder := range perfdataForwarders {
+		pending += len(forwarder.queue)
+	}
+
+	return pending
+}
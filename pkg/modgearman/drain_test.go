@@ -0,0 +1,96 @@
+package modgearman
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.statusAdmin = true
+	mainworker := &mainWorker{
+		cfg:           &cfg,
+		workerMap:     make(map[string]*worker),
+		workerMapLock: new(sync.RWMutex),
+		serverStatus:  make(map[string]string),
+		drainExit:     make(chan bool, 1),
+		running:       true,
+	}
+	mainworker.curBallooningWorker.Store(1)
+	wrk := &worker{id: "w1", what: "check", config: &cfg, mainWorker: mainworker}
+	mainworker.workerMap[wrk.id] = wrk
+	currentMainWorker.Store(mainworker)
+	defer currentMainWorker.Store(nil)
+
+	mux := http.NewServeMux()
+	registerStatusHandlers(mux, &cfg)
+
+	// undrain without drain
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/undrain", http.NoBody))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/drain?exit=yes", http.NoBody))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	status := statusDrain{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.True(t, status.Draining)
+	assert.True(t, status.Exit)
+	assert.True(t, mainworker.isDraining())
+	assert.Empty(t, mainworker.manageWorkers(0))
+
+	// all check worker get stopped, the backgrounded job is still running
+	require.Eventually(t, func() bool {
+		return len(mainworker.listWorkers()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	drain := mainworker.statusDrain()
+	assert.False(t, drain.Finished)
+	require.NotNil(t, drain.Progress)
+	assert.Equal(t, 1, drain.Progress.BallooningJobs)
+
+	mainworker.curBallooningWorker.Store(0)
+	select {
+	case <-mainworker.drainExit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish")
+	}
+	assert.True(t, mainworker.statusDrain().Finished)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/undrain", http.NoBody))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, mainworker.isDraining())
+	assert.False(t, mainworker.statusDrain().Finished)
+}
+
+func TestDrainUndrainBeforeFinished(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	mainworker := &mainWorker{
+		cfg:           &cfg,
+		workerMap:     make(map[string]*worker),
+		workerMapLock: new(sync.RWMutex),
+		drainExit:     make(chan bool, 1),
+	}
+	mainworker.curBallooningWorker.Store(1)
+
+	assert.True(t, mainworker.startDrain(true))
+	assert.False(t, mainworker.startDrain(false), "already draining")
+	assert.False(t, mainworker.statusDrain().Exit)
+	assert.True(t, mainworker.stopDrain())
+
+	// outdated drain routine must not trigger an exit
+	mainworker.curBallooningWorker.Store(0)
+	time.Sleep(2 * drainCheckInterval)
+	assert.Empty(t, mainworker.drainExit)
+	assert.False(t, mainworker.statusDrain().Finished)
+}
@@ -1,6 +1,9 @@
 package modgearman
 
 import (
+	"errors"
+	"path/filepath"
+	"strings"
 	time "time"
 
 	"github.com/appscode/g2/client"
@@ -11,6 +14,7 @@ type dupServerConsumer struct {
 	address            string
 	terminationRequest chan bool
 	config             *config
+	backlog            *resultSpool // optional persistent backlog
 }
 
 var dupServerConsumers map[string]*dupServerConsumer
@@ -26,6 +30,7 @@ func initializeDupServerConsumers(config *config) {
 				address:            dupAddress,
 				config:             config,
 			}
+			consumer.initializeBacklog()
 
 			dupServerConsumers[dupAddress] = consumer
 			go runDupServerConsumer(consumer)
@@ -33,6 +38,32 @@ func initializeDupServerConsumers(config *config) {
 	}
 }
 
+// initializeBacklog creates the persistent backlog if dupserver_backlog_dir is set
+func (dupServer *dupServerConsumer) initializeBacklog() {
+	if dupServer.config.dupServerBacklogDir == "" {
+		return
+	}
+
+	folder := strings.NewReplacer(":", "_", "/", "_").Replace(dupServer.address)
+	backlog, err := newResultSpool(
+		filepath.Join(dupServer.config.dupServerBacklogDir, folder),
+		dupServer.config.dupServerBacklogMaxSize*1024*1024,
+		time.Duration(dupServer.config.dupServerBacklogMaxAge)*time.Second,
+	)
+	if err != nil {
+		log.Errorf("persistent backlog for dupserver %s disabled: %s", dupServer.address, err.Error())
+
+		return
+	}
+	backlog.overflow = dupServer.config.dupServerBacklogOverflow
+	backlog.setMetrics(nil, nil, dupServerDroppedCounter.WithLabelValues(dupServer.address))
+	if backlog.Len() > 0 {
+		log.Infof("found %d backlog result(s) for dupserver %s, will be replayed", backlog.Len(), dupServer.address)
+	}
+	dupServer.backlog = backlog
+	dupServer.updateQueuedMetric()
+}
+
 func terminateDupServerConsumers() bool {
 	log.Debugf("Terminating DupServers")
 	for _, consumer := range dupServerConsumers {
@@ -47,6 +78,12 @@ func terminateDupServerConsumers() bool {
 }
 
 func runDupServerConsumer(dupServer *dupServerConsumer) {
+	if dupServer.backlog != nil {
+		runDupServerBacklogConsumer(dupServer)
+
+		return
+	}
+
 	var clt *client.Client
 	var item *answer
 	var err error
@@ -77,10 +114,120 @@ func runDupServerConsumer(dupServer *dupServerConsumer) {
 
 				break
 			}
+			dupServer.updateQueuedMetric()
+		}
+	}
+}
+
+// runDupServerBacklogConsumer sends results and moves them into the persistent backlog
+// as long as the dupserver is not reachable.
+func runDupServerBacklogConsumer(dupServer *dupServerConsumer) {
+	var clt *client.Client
+	var err error
+	var replayed int
+	var pending *answer // result waiting for space in the backlog, only used with overflow policy block
+
+	send := func(curClient *client.Client, result *answer) (*client.Client, error) {
+		return sendResultDup(curClient, result, dupServer.address, dupServer.config)
+	}
+
+	ticker := time.NewTicker(resultSpoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		// stop reading the queue while the backlog is full, so enqueue blocks
+		queue := dupServer.queue
+		if pending != nil {
+			queue = nil
+		}
+
+		select {
+		case <-dupServer.terminationRequest:
+			if clt != nil {
+				clt.Close()
+			}
+			if pending != nil {
+				dupServer.dropFromBacklog(pending)
+			}
+			dupServer.backlogPendingResults()
+
+			return
+		case item := <-queue:
+			// keep results in order as long as there are results waiting for replay
+			if dupServer.backlog.Len() > 0 {
+				if !dupServer.addToBacklog(item) {
+					pending = item
+				}
+
+				continue
+			}
+			clt, err = send(clt, item)
+			if err != nil {
+				clt = nil
+				log.Debugf("failed to send back result (to dupserver), moving to backlog: %w", err)
+				if !dupServer.addToBacklog(item) {
+					pending = item
+				}
+
+				continue
+			}
+			dupServer.updateQueuedMetric()
+		case <-ticker.C:
+			clt, replayed = replaySpooledResults(dupServer.backlog, send, clt)
+			dupServerReplayedCounter.WithLabelValues(dupServer.address).Add(float64(replayed))
+			if pending != nil && dupServer.addToBacklog(pending) {
+				pending = nil
+			}
+			dupServer.updateQueuedMetric()
 		}
 	}
 }
 
+// addToBacklog writes the result into the persistent backlog. Returns false if the backlog is full
+// and the overflow policy is block, the result has not been dropped then and must be added again later.
+func (dupServer *dupServerConsumer) addToBacklog(result *answer) bool {
+	defer dupServer.updateQueuedMetric()
+
+	err := dupServer.backlog.Add(result)
+	switch {
+	case err == nil:
+	case errors.Is(err, errResultSpoolFull) && dupServer.backlog.overflow == backlogOverflowBlock:
+		return false
+	default:
+		log.Debugf("dropping result (to dupserver %s): %s", dupServer.address, err.Error())
+	}
+
+	return true
+}
+
+// dropFromBacklog drops a result which did not fit into the full backlog
+func (dupServer *dupServerConsumer) dropFromBacklog(result *answer) {
+	dupServerDroppedCounter.WithLabelValues(dupServer.address).Inc()
+	log.Warnf("backlog for dupserver %s is full, dropping result for host: %s service: %s",
+		dupServer.address, result.hostName, result.serviceDescription)
+}
+
+// backlogPendingResults moves all results still waiting in the queue into the persistent backlog
+func (dupServer *dupServerConsumer) backlogPendingResults() {
+	for {
+		select {
+		case result := <-dupServer.queue:
+			if !dupServer.addToBacklog(result) {
+				dupServer.dropFromBacklog(result)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (dupServer *dupServerConsumer) updateQueuedMetric() {
+	queued := len(dupServer.queue)
+	if dupServer.backlog != nil {
+		queued += dupServer.backlog.Len()
+	}
+	dupServerQueuedCount.WithLabelValues(dupServer.address).Set(float64(queued))
+}
+
 func sendResultDup(clt *client.Client, item *answer, dupAddress string, config *config) (*client.Client, error) {
 	if config.dupResultsArePassive {
 		item.active = "passive"
@@ -96,15 +243,73 @@ func enqueueDupServerResult(config *config, result *answer) {
 	duplicateResult := *result
 
 	for _, dupAddress := range config.dupserver {
-		channel := dupServerConsumers[dupAddress].queue
+		consumer, ok := dupServerConsumers[dupAddress]
+		if !ok {
+			continue
+		}
+		consumer.enqueue(&duplicateResult)
+	}
+}
+
+// enqueue puts the result into the queue and applies the overflow policy if the queue is full
+func (dupServer *dupServerConsumer) enqueue(result *answer) {
+	defer dupServer.updateQueuedMetric()
+
+	select {
+	case dupServer.queue <- result:
+		return
+	default:
+	}
+
+	// queue is full, overflow into the persistent backlog. With overflow policy block
+	// wait for the queue instead, the consumer moves results into the backlog as long
+	// as there is space left and keeps them in order.
+	if dupServer.backlog != nil && dupServer.config.dupServerBacklogOverflow != backlogOverflowBlock {
+		dupServer.addToBacklog(result)
+
+		return
+	}
+
+	switch dupServer.config.dupServerBacklogOverflow {
+	case backlogOverflowBlock:
+		// never block the worker without a backlog, ex.: if it could not be created
+		if dupServer.backlog == nil {
+			break
+		}
+		for isRunning() {
+			select {
+			case dupServer.queue <- result:
+				return
+			case <-time.After(1 * time.Second):
+			}
+		}
+		// shutting down, keep the result in the backlog if there is space left
+		if dupServer.addToBacklog(result) {
+			return
+		}
+	case backlogOverflowDropOldest:
 		select {
-		case channel <- &duplicateResult:
+		case <-dupServer.queue:
+			dupServerDroppedCounter.WithLabelValues(dupServer.address).Inc()
 		default:
+		}
+		select {
+		case dupServer.queue <- result:
 			log.Debugf(
-				"channel is at capacity (%d), dropping message (to dupserver): %s",
-				config.dupServerBacklogQueueSize,
-				dupAddress,
+				"channel is at capacity (%d), dropped oldest message (to dupserver): %s",
+				dupServer.config.dupServerBacklogQueueSize,
+				dupServer.address,
 			)
+
+			return
+		default:
 		}
 	}
+
+	dupServerDroppedCounter.WithLabelValues(dupServer.address).Inc()
+	log.Debugf(
+		"channel is at capacity (%d), dropping message (to dupserver): %s",
+		dupServer.config.dupServerBacklogQueueSize,
+		dupServer.address,
+	)
 }
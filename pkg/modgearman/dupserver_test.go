@@ -0,0 +1,157 @@
+package modgearman
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDupServerOverflow(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+	atomic.StoreInt64(&aIsRunning, 1)
+	defer atomic.StoreInt64(&aIsRunning, 0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.dupServerBacklogQueueSize = 2
+
+	for _, test := range []struct {
+		overflow string
+		expect   []string
+	}{
+		{backlogOverflowDropNewest, []string{"host1", "host2"}},
+		{backlogOverflowDropOldest, []string{"host2", "host3"}},
+		// without backlog block must not stall the worker
+		{backlogOverflowBlock, []string{"host1", "host2"}},
+	} {
+		cfg.dupServerBacklogOverflow = test.overflow
+		consumer := &dupServerConsumer{
+			queue:   make(chan *answer, cfg.dupServerBacklogQueueSize),
+			address: "127.0.0.1:4730",
+			config:  &cfg,
+		}
+		for _, host := range []string{"host1", "host2", "host3"} {
+			consumer.enqueue(&answer{hostName: host})
+		}
+		close(consumer.queue)
+		got := []string{}
+		for item := range consumer.queue {
+			got = append(got, item.hostName)
+		}
+		assert.Equalf(t, test.expect, got, "overflow policy %s", test.overflow)
+	}
+}
+
+func TestDupServerBlockConfig(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.server = []string{"localhost:4730"}
+	cfg.encryption = false
+	cfg.services = true
+
+	cfg.dupServerBacklogOverflow = backlogOverflowBlock
+	require.Error(t, checkForReasonableConfig(&cfg))
+
+	cfg.dupServerBacklogDir = t.TempDir()
+	require.NoError(t, checkForReasonableConfig(&cfg))
+}
+
+func TestDupServerBacklog(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.dupServerBacklogQueueSize = 1
+	cfg.dupServerBacklogDir = t.TempDir()
+
+	consumer := &dupServerConsumer{
+		queue:   make(chan *answer, cfg.dupServerBacklogQueueSize),
+		address: "127.0.0.1:4730",
+		config:  &cfg,
+	}
+	consumer.initializeBacklog()
+	require.NotNil(t, consumer.backlog)
+
+	// full queue overflows into persistent backlog
+	for _, host := range []string{"host1", "host2", "host3"} {
+		consumer.enqueue(&answer{hostName: host})
+	}
+	assert.Len(t, consumer.queue, 1)
+	assert.Equal(t, 2, consumer.backlog.Len())
+
+	// pending results are moved to the backlog as well
+	consumer.backlogPendingResults()
+	assert.Empty(t, consumer.queue)
+	assert.Equal(t, 3, consumer.backlog.Len())
+
+	_, result, err := consumer.backlog.Oldest()
+	require.NoError(t, err)
+	assert.Equal(t, "host2", result.hostName)
+}
+
+func TestDupServerBacklogBlock(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+	atomic.StoreInt64(&aIsRunning, 1)
+	defer atomic.StoreInt64(&aIsRunning, 0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.dupServerBacklogQueueSize = 1
+	cfg.dupServerBacklogDir = t.TempDir()
+	cfg.dupServerBacklogOverflow = backlogOverflowBlock
+
+	consumer := &dupServerConsumer{
+		terminationRequest: make(chan bool),
+		queue:              make(chan *answer, cfg.dupServerBacklogQueueSize),
+		address:            "127.0.0.1:1",
+		config:             &cfg,
+	}
+	consumer.initializeBacklog()
+	require.NotNil(t, consumer.backlog)
+
+	// backlog is full after the first result
+	require.True(t, consumer.addToBacklog(&answer{hostName: "host1"}))
+	consumer.backlog.lock.Lock()
+	consumer.backlog.maxSize = consumer.backlog.size
+	consumer.backlog.lock.Unlock()
+	require.False(t, consumer.addToBacklog(&answer{hostName: "host2"}), "result must not be dropped")
+
+	go runDupServerConsumer(consumer)
+	enqueued := make(chan bool, 1)
+	go func() {
+		for _, host := range []string{"host2", "host3", "host4"} {
+			consumer.enqueue(&answer{hostName: host})
+		}
+		enqueued <- true
+	}()
+
+	// host2 waits for space in the backlog, host3 in the queue, so host4 blocks
+	select {
+	case <-enqueued:
+		t.Fatal("enqueue must block while the backlog is full")
+	case <-time.After(500 * time.Millisecond):
+	}
+	assert.Equal(t, 1, consumer.backlog.Len())
+
+	// all results end up in the backlog in order once there is space again
+	consumer.backlog.lock.Lock()
+	consumer.backlog.maxSize = 0
+	consumer.backlog.lock.Unlock()
+	select {
+	case <-enqueued:
+	case <-time.After(3 * resultSpoolReplayInterval):
+		t.Fatal("enqueue still blocked")
+	}
+	consumer.terminationRequest <- true
+	assert.Equal(t, 4, consumer.backlog.Len())
+
+	_, result, err := consumer.backlog.Oldest()
+	require.NoError(t, err)
+	assert.Equal(t, "host1", result.hostName)
+}
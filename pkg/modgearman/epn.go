@@ -294,11 +294,10 @@ func executeWithEmbeddedPerl(cmd *command, result *answer, received *request) er
 	}
 	defer con.Close()
 
-	received.Cancel = func() {
+	received.setCancel(func() {
 		log.Debugf("cancel epn job")
-		received.Canceled = true
 		con.Close()
-	}
+	})
 
 	_, err = con.Write(msg)
 	if err != nil {
@@ -315,7 +314,7 @@ func executeWithEmbeddedPerl(cmd *command, result *answer, received *request) er
 		result.timedOut = true
 	}
 
-	received.Cancel = nil
+	received.setCancel(nil)
 
 	if len(buf) == 0 {
 		return fmt.Errorf("zero sized result, epn worker closed connection")
@@ -334,7 +333,7 @@ func executeWithEmbeddedPerl(cmd *command, result *answer, received *request) er
 		log.Errorf("found epn error, triggering epn server restart")
 		log.Errorf("%s", res.Stdout)
 		ePNStarted = nil
-		received.Canceled = true
+		received.setCanceled(true)
 
 		return fmt.Errorf("check result matched restart pattern: %s", pattern)
 	}
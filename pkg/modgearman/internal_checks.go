@@ -15,6 +15,8 @@ type InternalCheck interface {
 
 func execInternal(result *answer, cmd *command, received *request) {
 	log.Tracef("using internal check for: %s", cmd.Command)
+	_, endSpan := startExecSpan(received, cmd, "execInternal")
+	defer endSpan()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(received.timeout)*time.Second)
 	defer cancel()
@@ -0,0 +1,136 @@
+package modgearman
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kdar/factorlog"
+)
+
+// logFields contains structured fields which will be added to json log output
+// and are omitted from the text log output
+type logFields map[string]any
+
+// logMessage defers formatting the log message until it will be written
+type logMessage struct {
+	format string
+	args   []any
+}
+
+func (m *logMessage) String() string {
+	return fmt.Sprintf(m.format, m.args...)
+}
+
+// log message with given severity and additional structured fields
+func logWithFields(sev factorlog.Severity, fields logFields, format string, args ...any) {
+	logErr := log.Output(sev, 2, &logMessage{format: format, args: args}, fields)
+	if logErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to log: %s (%s)", fmt.Sprintf(format, args...), logErr.Error())
+	}
+}
+
+// splitLogFields removes structured fields from the log arguments
+func splitLogFields(args []any) (remaining []any, fields logFields) {
+	for i, arg := range args {
+		if f, ok := arg.(logFields); ok {
+			if remaining == nil {
+				remaining = append(make([]any, 0, len(args)), args[:i]...)
+			}
+			fields = f
+
+			continue
+		}
+		if remaining != nil {
+			remaining = append(remaining, arg)
+		}
+	}
+	if remaining == nil {
+		remaining = args
+	}
+
+	return remaining, fields
+}
+
+func formatLogMessage(context *factorlog.LogContext) string {
+	if context.Format != nil {
+		return fmt.Sprintf(*context.Format, context.Args...)
+	}
+
+	return fmt.Sprint(context.Args...)
+}
+
+// textLogFormatter is the factorlog std formatter which skips structured fields
+type textLogFormatter struct {
+	std *factorlog.StdFormatter
+}
+
+func newTextLogFormatter(frmt string) *textLogFormatter {
+	return &textLogFormatter{std: factorlog.NewStdFormatter(frmt)}
+}
+
+func (f *textLogFormatter) ShouldRuntimeCaller() bool {
+	return f.std.ShouldRuntimeCaller()
+}
+
+func (f *textLogFormatter) Format(context factorlog.LogContext) []byte {
+	context.Args, _ = splitLogFields(context.Args)
+
+	return f.std.Format(context)
+}
+
+// jsonLogFormatter writes one json object per log entry
+type jsonLogFormatter struct{}
+
+func (f *jsonLogFormatter) ShouldRuntimeCaller() bool {
+	return true
+}
+
+func (f *jsonLogFormatter) Format(context factorlog.LogContext) []byte {
+	var fields logFields
+	context.Args, fields = splitLogFields(context.Args)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"timestamp":`)
+	writeJSONLogValue(buf, context.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"severity":`)
+	writeJSONLogValue(buf, factorlog.UcSeverityStrings[factorlog.SeverityToIndex(context.Severity)])
+	buf.WriteString(`,"pid":`)
+	writeJSONLogValue(buf, context.Pid)
+	buf.WriteString(`,"file":`)
+	writeJSONLogValue(buf, filepath.Base(context.File))
+	buf.WriteString(`,"line":`)
+	writeJSONLogValue(buf, context.Line)
+	buf.WriteString(`,"message":`)
+	writeJSONLogValue(buf, formatLogMessage(&context))
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(",")
+		writeJSONLogValue(buf, key)
+		buf.WriteString(":")
+		writeJSONLogValue(buf, fields[key])
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+func writeJSONLogValue(buf *bytes.Buffer, val any) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		// do not log errors here, the logger lock is held while formatting
+		_ = enc.Encode(fmt.Sprintf("%v", val))
+	}
+	// remove trailing newline added by the encoder
+	buf.Truncate(buf.Len() - 1)
+}
@@ -2,6 +2,7 @@ package modgearman
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"runtime/debug"
 
@@ -26,9 +27,16 @@ func createLogger(config *config) {
 	// check in config file if file is specified
 	verbosity := getSeverity(config.debug)
 
+	var conn net.Conn
+	var formatter factorlog.Formatter
+	var err error
 	switch {
 	case config.debug >= LogLevelTrace2 || config.logfile == "stderr":
 		log.SetOutput(os.Stderr)
+	case config.logmode == "syslog":
+		conn, formatter, err = createSyslogLogger(config)
+	case config.logmode == "journald":
+		conn, formatter, err = createJournaldLogger(config)
 	case config.logfile != "" && (config.logmode == "automatic" || config.logmode == "file"):
 		file, err := openFileOrCreate(config.logfile)
 		if err != nil {
@@ -44,7 +52,30 @@ func createLogger(config *config) {
 		log.SetOutput(os.Stdout)
 	}
 
-	log.SetFormatter(factorlog.NewStdFormatter(frmt))
+	if conn != nil {
+		log.SetOutput(conn)
+	}
+	// close previous syslog/journald connection after switching the output
+	if logConnection != nil {
+		logConnection.Close()
+	}
+	logConnection = conn
+
+	switch {
+	case formatter != nil:
+		log.SetFormatter(formatter)
+	case config.logformat == "json":
+		log.SetFormatter(&jsonLogFormatter{})
+	case config.logformat == "" || config.logformat == "text":
+		log.SetFormatter(newTextLogFormatter(frmt))
+	default:
+		log.SetFormatter(newTextLogFormatter(frmt))
+		log.Warnf("unknown logformat: '%s'. Defaulting to 'text'", config.logformat)
+	}
+	if err != nil {
+		log.SetOutput(os.Stdout)
+		log.Errorf("could not create %s logger, falling back to stdout: %s", config.logmode, err.Error())
+	}
 	log.SetMinMaxSeverity(factorlog.StringToSeverity(verbosity), factorlog.StringToSeverity("PANIC"))
 	log.SetVerbosity(0)
 	if config.debug >= 2 {
@@ -1,12 +1,18 @@
 package modgearman
 
 import (
+	"encoding/json"
 	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kdar/factorlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateLogger(t *testing.T) {
@@ -81,3 +87,125 @@ func TestCreateLogger(t *testing.T) {
 		t.Errorf("could not remove loggertest")
 	}
 }
+
+func TestCreateLoggerJSON(t *testing.T) {
+	logfile := filepath.Join(t.TempDir(), "loggertest.json")
+	cfg := config{}
+	cfg.logfile = logfile
+	cfg.logmode = "file"
+	cfg.logformat = "json"
+	cfg.debug = 1
+
+	createLogger(&cfg)
+	defer func() {
+		cfg.logformat = "text"
+		cfg.logfile = ""
+		cfg.debug = 0
+		createLogger(&cfg)
+	}()
+
+	log.Infof("test <%s>", "message")
+	logWithFields(factorlog.DEBUG, logFields{"host_name": "testhost", "return_code": 2}, "job %s finished", "H:1")
+
+	content, err := os.ReadFile(logfile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "INFO", entry["severity"])
+	assert.Equal(t, "test <message>", entry["message"])
+	assert.Equal(t, "logger_test.go", entry["file"])
+
+	entry = map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "DEBUG", entry["severity"])
+	assert.Equal(t, "job H:1 finished", entry["message"])
+	assert.Equal(t, "testhost", entry["host_name"])
+	assert.InDelta(t, 2, entry["return_code"], 0)
+	assert.Equal(t, "logger_test.go", entry["file"])
+
+	// text format skips the fields
+	cfg.logformat = "text"
+	createLogger(&cfg)
+	logWithFields(factorlog.DEBUG, logFields{"host_name": "testhost"}, "job %s finished", "H:2")
+	content, err = os.ReadFile(logfile)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[2], "job H:2 finished")
+	assert.NotContains(t, lines[2], "testhost")
+}
+
+func TestCreateLoggerSyslog(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "syslog.sock")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	cfg := config{}
+	cfg.logmode = "syslog"
+	cfg.syslogAddress = "unix://" + socket
+	cfg.syslogFacility = "local3"
+	cfg.syslogTag = "testworker"
+	cfg.debug = 1
+
+	createLogger(&cfg)
+	defer func() {
+		cfg.logmode = "automatic"
+		cfg.debug = 0
+		createLogger(&cfg)
+	}()
+	require.NotNil(t, logConnection)
+
+	log.Warnf("test %s", "warning")
+
+	buf := make([]byte, 4096)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(5*time.Second)))
+	size, err := listener.Read(buf)
+	require.NoError(t, err)
+	msg := string(buf[:size])
+
+	// local3 (19) * 8 + warning (4)
+	assert.True(t, strings.HasPrefix(msg, "<156>1 "), "syslog header: %s", msg)
+	assert.Contains(t, msg, " testworker ")
+	assert.Contains(t, msg, "[logger_test.go:")
+	assert.True(t, strings.HasSuffix(msg, "test warning"), "syslog message: %s", msg)
+}
+
+func TestSyslogAddress(t *testing.T) {
+	network, addr, err := parseSyslogAddress("")
+	require.NoError(t, err)
+	assert.Equal(t, "unixgram", network)
+	assert.Equal(t, "/dev/log", addr)
+
+	network, addr, err = parseSyslogAddress("udp://loghost")
+	require.NoError(t, err)
+	assert.Equal(t, "udp", network)
+	assert.Equal(t, "loghost:514", addr)
+
+	_, _, err = parseSyslogAddress("tcp://loghost:514")
+	require.Error(t, err)
+
+	_, err = getSyslogFacility("unknown")
+	require.Error(t, err)
+}
+
+func TestJournaldLogFormatter(t *testing.T) {
+	formatter := &journaldLogFormatter{facility: 3, tag: "testworker"}
+	format := "job %s finished"
+	msg := formatter.Format(factorlog.LogContext{
+		Severity: factorlog.ERROR,
+		Format:   &format,
+		Args:     []any{"H:1", logFields{"host_name": "testhost"}},
+		File:     "/tmp/worker.go",
+		Line:     12,
+	})
+
+	assert.Contains(t, string(msg), "PRIORITY=3\n")
+	assert.Contains(t, string(msg), "SYSLOG_IDENTIFIER=testworker\n")
+	assert.Contains(t, string(msg), "CODE_FILE=worker.go\n")
+	assert.Contains(t, string(msg), "MODGEARMAN_HOST_NAME=testhost\n")
+	assert.Contains(t, string(msg), "MESSAGE=job H:1 finished\n")
+}
@@ -0,0 +1,273 @@
+package modgearman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kdar/factorlog"
+)
+
+const (
+	// journaldSocket is the native journald protocol socket
+	journaldSocket = "/run/systemd/journal/socket"
+
+	// defaultSyslogAddress is used if no syslog_address is configured
+	defaultSyslogAddress = "unix:///dev/log"
+
+	// defaultSyslogPort is used for udp syslog addresses without port
+	defaultSyslogPort = "514"
+
+	// syslogMessageFormat sets the message format, timestamp and severity are part of the syslog header
+	syslogMessageFormat = `[%{File}:%{Line}] %{Message}`
+)
+
+// syslog severities as defined in RFC5424
+const (
+	syslogEmergency = iota
+	syslogAlert
+	syslogCritical
+	syslogError
+	syslogWarning
+	syslogNotice
+	syslogInfo
+	syslogDebug
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// current syslog/journald connection, will be closed when the logger is recreated
+var logConnection net.Conn
+
+// syslogPriority maps factorlog severities to syslog severities
+func syslogPriority(sev factorlog.Severity) int {
+	switch sev {
+	case factorlog.TRACE, factorlog.DEBUG:
+		return syslogDebug
+	case factorlog.INFO:
+		return syslogInfo
+	case factorlog.WARN:
+		return syslogWarning
+	case factorlog.ERROR, factorlog.STACK:
+		return syslogError
+	case factorlog.CRITICAL, factorlog.FATAL:
+		return syslogCritical
+	case factorlog.PANIC:
+		return syslogAlert
+	}
+
+	return syslogNotice
+}
+
+func getSyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %s", name)
+	}
+
+	return facility, nil
+}
+
+func getSyslogTag(config *config) string {
+	switch {
+	case config.syslogTag != "":
+		return config.syslogTag
+	case config.binary != "":
+		return config.binary
+	}
+
+	return "mod_gearman_worker"
+}
+
+// parseSyslogAddress returns network and address for given syslog address
+// supported formats are unix:///dev/log, udp://host:port or a plain socket path
+func parseSyslogAddress(address string) (network, addr string, err error) {
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+	scheme, target, ok := strings.Cut(address, "://")
+	if !ok {
+		return "unixgram", address, nil
+	}
+
+	switch strings.ToLower(scheme) {
+	case "unix":
+		return "unixgram", target, nil
+	case "udp":
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, defaultSyslogPort)
+		}
+
+		return "udp", target, nil
+	default:
+		return "", "", fmt.Errorf("unsupported syslog address %s, use unix:// or udp://", address)
+	}
+}
+
+// createSyslogLogger connects the logger to syslog and returns an error if that is not possible
+func createSyslogLogger(config *config) (net.Conn, factorlog.Formatter, error) {
+	facility, err := getSyslogFacility(config.syslogFacility)
+	if err != nil {
+		return nil, nil, err
+	}
+	network, addr, err := parseSyslogAddress(config.syslogAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.DialTimeout(network, addr, DefaultConnectionTimeout*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to syslog at %s: %w", addr, err)
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	var message factorlog.Formatter = newTextLogFormatter(syslogMessageFormat)
+	if config.logformat == "json" {
+		message = &jsonLogFormatter{}
+	}
+
+	return conn, &syslogLogFormatter{
+		message:  message,
+		facility: facility,
+		hostname: hostname,
+		tag:      getSyslogTag(config),
+	}, nil
+}
+
+// createJournaldLogger connects the logger to the journald native socket
+func createJournaldLogger(config *config) (net.Conn, factorlog.Formatter, error) {
+	facility, err := getSyslogFacility(config.syslogFacility)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.DialTimeout("unixgram", journaldSocket, DefaultConnectionTimeout*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot connect to journald at %s: %w", journaldSocket, err)
+	}
+
+	return conn, &journaldLogFormatter{
+		facility: facility,
+		tag:      getSyslogTag(config),
+	}, nil
+}
+
+// syslogLogFormatter creates RFC5424 syslog messages
+type syslogLogFormatter struct {
+	message  factorlog.Formatter
+	facility int
+	hostname string
+	tag      string
+}
+
+func (f *syslogLogFormatter) ShouldRuntimeCaller() bool {
+	return true
+}
+
+func (f *syslogLogFormatter) Format(context factorlog.LogContext) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d - - ",
+		f.facility*8+syslogPriority(context.Severity),
+		context.Time.Format(time.RFC3339Nano),
+		f.hostname,
+		f.tag,
+		context.Pid,
+	)
+	buf.Write(bytes.TrimRight(f.message.Format(context), "\n"))
+
+	return buf.Bytes()
+}
+
+// journaldLogFormatter creates messages using the journald native protocol
+type journaldLogFormatter struct {
+	facility int
+	tag      string
+}
+
+func (f *journaldLogFormatter) ShouldRuntimeCaller() bool {
+	return true
+}
+
+func (f *journaldLogFormatter) Format(context factorlog.LogContext) []byte {
+	var fields logFields
+	context.Args, fields = splitLogFields(context.Args)
+
+	buf := &bytes.Buffer{}
+	writeJournaldField(buf, "PRIORITY", fmt.Sprintf("%d", syslogPriority(context.Severity)))
+	writeJournaldField(buf, "SYSLOG_FACILITY", fmt.Sprintf("%d", f.facility))
+	writeJournaldField(buf, "SYSLOG_IDENTIFIER", f.tag)
+	writeJournaldField(buf, "SYSLOG_PID", fmt.Sprintf("%d", context.Pid))
+	writeJournaldField(buf, "CODE_FILE", filepath.Base(context.File))
+	writeJournaldField(buf, "CODE_LINE", fmt.Sprintf("%d", context.Line))
+	if context.Function != "" {
+		writeJournaldField(buf, "CODE_FUNC", context.Function)
+	}
+	for key, val := range fields {
+		writeJournaldField(buf, journaldFieldName(key), fmt.Sprintf("%v", val))
+	}
+	writeJournaldField(buf, "MESSAGE", formatLogMessage(&context))
+
+	return buf.Bytes()
+}
+
+// journaldFieldName converts a field name into a valid journald field name
+func journaldFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+
+		return '_'
+	}, key)
+
+	return "MODGEARMAN_" + name
+}
+
+// writeJournaldField appends a single field, values containing newlines use the binary format
+func writeJournaldField(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+
+		return
+	}
+	buf.WriteByte('\n')
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(value)))
+	buf.Write(size)
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
@@ -32,43 +32,55 @@ const (
  */
 
 type mainWorker struct {
-	activeWorkers       int
-	workerUtilization   int
+	activeWorkers       atomic.Int64
+	workerUtilization   atomic.Int64
 	workerMap           map[string]*worker
 	workerMapLock       *sync.RWMutex
 	statusWorker        *worker
-	min1                float64
-	min5                float64
-	min15               float64
-	memTotal            uint64
-	memFree             uint64
+	min1                float64 // guarded by stateLock
+	min5                float64 // guarded by stateLock
+	min15               float64 // guarded by stateLock
+	memTotal            uint64  // guarded by stateLock
+	memFree             uint64  // guarded by stateLock
+	pressure            map[string]float64
+	pressureLock        sync.RWMutex
 	maxOpenFiles        uint64
 	maxPossibleWorker   int
-	curBallooningWorker int
+	curBallooningWorker atomic.Int64
 	cfg                 *config
 	key                 []byte
-	tasks               int
-	idleSince           time.Time
+	tasks               atomic.Int64
+	idleSince           time.Time // guarded by stateLock
+	stateLock           sync.RWMutex
 	serverStatus        map[string]string
+	queueStats          *queueStats
+	scalingBlocked      map[string]string // current blocked scaling reason by pool, only used by manageWorkers
+	drain               drainState
+	drainExit           chan bool
 	running             bool
 	cpuProfileHandler   *os.File
 }
 
 func newMainWorker(configuration *config, key []byte, workerMap map[string]*worker) *mainWorker {
 	wrk := &mainWorker{
-		activeWorkers: 0,
 		key:           key,
 		cfg:           configuration,
 		workerMap:     workerMap,
 		workerMapLock: new(sync.RWMutex),
 		idleSince:     time.Now(),
 		serverStatus:  make(map[string]string),
+		queueStats:    newQueueStats(),
+		drainExit:     make(chan bool, 1),
 	}
 	atomic.StoreInt64(&aIsRunning, 1)
 	wrk.InitDebugOptions()
 	wrk.RetryFailedConnections()
 	initializeResultServerConsumers(wrk.cfg)
 	initializeDupServerConsumers(wrk.cfg)
+	initializeResultSinks(wrk.cfg)
+	initializePerfdataForwarders(wrk.cfg)
+	initializeTracing(wrk.cfg)
+	initializeCgroups(wrk.cfg)
 
 	return wrk
 }
@@ -124,64 +136,78 @@ func (w *mainWorker) manageWorkers(initialStart int) (reason string) {
 	activeWorkers := 0
 	totalWorker := len(w.workerMap)
 	for _, w := range w.workerMap {
-		if w.activeJobs > 0 {
+		if w.isBusy() {
 			activeWorkers++
 		}
 	}
-	w.activeWorkers = activeWorkers
+	w.activeWorkers.Store(int64(activeWorkers))
+	w.updatePressure()
 	log.Tracef("manageWorkers: total: %d, active: %d (min: %d, max: %d)",
 		totalWorker, activeWorkers, w.cfg.minWorker, w.cfg.maxWorker)
 	workerCount.Set(float64(totalWorker))
 	workingWorkerCount.Set(float64(activeWorkers))
 	idleWorkerCount.Set(float64(totalWorker - activeWorkers))
 
-	// as long as there are to few workers start them without a limit
-	minWorker := w.cfg.minWorker
-	if initialStart > 0 {
-		minWorker = initialStart
-	}
-	log.Tracef("manageWorkers: total: %d, active: %d, minWorker: %d", totalWorker, activeWorkers, minWorker)
-	for i := minWorker - len(w.workerMap); i > 0; i-- {
-		log.Tracef("manageWorkers: starting minworker: %d, %d", minWorker-len(w.workerMap), i)
-		worker := newWorker("check", w.cfg, w)
-		w.registerWorker(worker)
-		w.idleSince = time.Now()
-	}
-
 	if totalWorker > 0 {
-		w.workerUtilization = (activeWorkers * 100) / totalWorker
+		w.workerUtilization.Store(int64((activeWorkers * 100) / totalWorker))
 	} else {
-		w.workerUtilization = 0
+		w.workerUtilization.Store(0)
 	}
 
-	// check if we have too many workers
-	w.adjustWorkerBottomLevel()
+	// do not start any worker while draining
+	if w.isDraining() {
+		return ""
+	}
 
-	// check if we need more workers
-	reason = w.adjustWorkerTopLevel()
+	// each pool is managed independently, initial start only applies to the default pool
+	poolWorkerCount.Reset()
+	poolWorkingWorkerCount.Reset()
+	poolIdleWorkerCount.Reset()
+	for _, pool := range w.cfg.workerPools() {
+		poolStart := 0
+		if pool.name == defaultWorkerPool {
+			poolStart = initialStart
+		}
+		if poolReason := w.managePool(pool, poolStart); reason == "" {
+			reason = poolReason
+		}
+	}
 
 	newTotalWorker := len(w.workerMap)
 	if newTotalWorker != totalWorker {
-		log.Debugf("adjusted workers: %d (utilization: %d%%)", newTotalWorker, w.workerUtilization)
+		log.Debugf("adjusted workers: %d (utilization: %d%%)", newTotalWorker, w.workerUtilization.Load())
 	}
 
 	return reason
 }
 
 // check if we need more workers and start new ones
-func (w *mainWorker) adjustWorkerTopLevel() (failreason string) {
-	// only if all are busy
-	if w.activeWorkers < len(w.workerMap) {
-		return ""
+func (w *mainWorker) adjustWorkerTopLevel(pool *workerPool) (failreason string) {
+	blocked := ""
+	defer func() { w.setScalingBlocked(pool, blocked) }()
+
+	poolWorker, activeWorkers := w.countWorker(pool.name)
+	reason := scalingReasonUtilization
+	// only if all are busy or if more jobs are waiting in the queues than idle workers are available
+	if activeWorkers < poolWorker {
+		waiting, _, ok := w.queueWaiting(pool)
+		if !ok || waiting <= poolWorker-activeWorkers {
+			return ""
+		}
+		reason = scalingReasonQueueWaiting
 	}
 	// do not exceed maxWorker level
-	if len(w.workerMap) >= w.cfg.maxWorker {
+	if poolWorker >= pool.maxWorker {
+		blocked = scalingReasonMaxWorker
+
 		return ""
 	}
 	// check load levels
 	w.updateLoadAvg()
-	passed, failreason := w.checkLoads()
+	passed, failreason := w.checkLoadLimits(pool.loadLimit1, pool.loadLimit5, pool.loadLimit15)
 	if !passed {
+		blocked = scalingReasonLoadLimit
+
 		return failreason
 	}
 
@@ -189,58 +215,70 @@ func (w *mainWorker) adjustWorkerTopLevel() (failreason string) {
 	w.updateMemInfo()
 	passed, failreason = w.checkMemory()
 	if !passed {
+		blocked = scalingReasonMemLimit
+
+		return failreason
+	}
+
+	// check pressure stall information
+	passed, failreason = w.checkPressure()
+	if !passed {
+		blocked = scalingReasonPsiLimit
+
 		return failreason
 	}
 
 	// start new workers at spawn speed
-	for range w.cfg.spawnRate {
-		if len(w.workerMap) >= w.cfg.maxWorker {
+	for range pool.spawnRate {
+		if poolWorker, _ = w.countWorker(pool.name); poolWorker >= pool.maxWorker {
 			break
 		}
-		log.Tracef("manageWorkers: starting one...")
-		worker := newWorker("check", w.cfg, w)
+		log.Tracef("manageWorkers: starting one for pool %s (%s)...", pool.name, reason)
+		worker := newWorker("check", pool, w.cfg, w)
 		w.registerWorker(worker)
-		w.idleSince = time.Now()
+		w.resetIdleSince()
+		countScalingDecision(pool, scalingActionStart, reason, 1)
 	}
 
 	return ""
 }
 
 // check if we have too many workers (less than 90% UtilizationWatermarkLow) active and above minWorker)
-func (w *mainWorker) adjustWorkerBottomLevel() {
-	if len(w.workerMap) == 0 {
+func (w *mainWorker) adjustWorkerBottomLevel(pool *workerPool) {
+	poolWorker, activeWorkers := w.countWorker(pool.name)
+	if poolWorker == 0 {
 		return
 	}
 	// below minimum level
-	if len(w.workerMap) <= w.cfg.minWorker {
+	if poolWorker <= pool.minWorker {
 		return
 	}
 	// above 90% (UtilizationWatermarkLow) utilization
-	if (w.activeWorkers / len(w.workerMap) * 100) >= UtilizationWatermarkLow {
+	if (activeWorkers / poolWorker * 100) >= UtilizationWatermarkLow {
 		return
 	}
-	// not idling long enough
-	if time.Now().Unix()-w.idleSince.Unix() <= int64(w.cfg.idleTimeout) {
+	// not idling long enough, unless the queues have been empty for a whole queue_scaling_interval
+	reason := scalingReasonIdleTimeout
+	_, emptySince, ok := w.queueWaiting(pool)
+	switch {
+	case ok && !emptySince.IsZero() && time.Since(emptySince) >= time.Duration(w.cfg.queueScalingInterval)*time.Second:
+		reason = scalingReasonQueueEmpty
+	case time.Now().Unix()-w.getIdleSince().Unix() <= int64(w.cfg.idleTimeout):
 		return
 	}
 
 	// reduce workers at sinkrate
-	sinkRate := w.cfg.sinkRate
+	sinkRate := pool.sinkRate
 	if sinkRate <= 0 {
-		sinkRate = w.cfg.spawnRate
+		sinkRate = pool.spawnRate
 	}
 	for range sinkRate {
-		if len(w.workerMap) <= w.cfg.minWorker {
+		if poolWorker, _ = w.countWorker(pool.name); poolWorker <= pool.minWorker {
 			break
 		}
 		// stop first idle worker
-		log.Debugf("manageWorkers: stopping one...")
-		for _, worker := range w.workerMap {
-			if worker.activeJobs == 0 {
-				worker.Shutdown()
-
-				break
-			}
+		if w.stopIdleWorker(pool.name) {
+			countScalingDecision(pool, scalingActionStop, reason, 1)
 		}
 	}
 }
@@ -256,10 +294,19 @@ func (w *mainWorker) applyConfigChanges() (restartRequired bool, cfg *config) {
 		return restartRequired, cfg
 	}
 
-	// restart prometheus if necessary
-	if cfg.prometheusServer != w.cfg.prometheusServer {
+	// restart prometheus and status api if necessary
+	if cfg.prometheusServer != w.cfg.prometheusServer || cfg.statusServer != w.cfg.statusServer || cfg.statusAdmin != w.cfg.statusAdmin {
 		stopPrometheus(prometheusListener)
 		prometheusListener = startPrometheus(cfg)
+		stopStatusServer(statusListener)
+		statusListener = startStatusServer(cfg)
+	}
+
+	// remove exported perfdata if the filters have changed
+	if cfg.perfdataMetrics != w.cfg.perfdataMetrics ||
+		strings.Join(cfg.perfdataMetricsAllow, "\n") != strings.Join(w.cfg.perfdataMetricsAllow, "\n") ||
+		strings.Join(cfg.perfdataMetricsDeny, "\n") != strings.Join(w.cfg.perfdataMetricsDeny, "\n") {
+		resetPerfdataSeries()
 	}
 
 	// restart epn worker if necessary
@@ -279,9 +326,37 @@ func (w *mainWorker) applyConfigChanges() (restartRequired bool, cfg *config) {
 	switch {
 	case strings.Join(cfg.server, "\n") != strings.Join(w.cfg.server, "\n"):
 		restartRequired = true
+	case cfg.queueScaling != w.cfg.queueScaling,
+		cfg.queueScalingInterval != w.cfg.queueScalingInterval:
+		restartRequired = true
 	case strings.Join(cfg.dupserver, "\n") != strings.Join(w.cfg.dupserver, "\n"):
 		restartRequired = true
-	case cfg.dupServerBacklogQueueSize != w.cfg.dupServerBacklogQueueSize:
+	case cfg.dupServerBacklogQueueSize != w.cfg.dupServerBacklogQueueSize,
+		cfg.dupServerBacklogDir != w.cfg.dupServerBacklogDir,
+		cfg.dupServerBacklogMaxSize != w.cfg.dupServerBacklogMaxSize,
+		cfg.dupServerBacklogMaxAge != w.cfg.dupServerBacklogMaxAge,
+		cfg.dupServerBacklogOverflow != w.cfg.dupServerBacklogOverflow:
+		restartRequired = true
+	case cfg.resultSpoolDir != w.cfg.resultSpoolDir,
+		cfg.resultSpoolMaxSize != w.cfg.resultSpoolMaxSize,
+		cfg.resultSpoolMaxAge != w.cfg.resultSpoolMaxAge:
+		restartRequired = true
+	case strings.Join(cfg.resultSinks, "\n") != strings.Join(w.cfg.resultSinks, "\n"):
+		restartRequired = true
+	case strings.Join(cfg.perfdataForward, "\n") != strings.Join(w.cfg.perfdataForward, "\n"),
+		cfg.perfdataForwardPrefix != w.cfg.perfdataForwardPrefix,
+		cfg.perfdataForwardBatchSize != w.cfg.perfdataForwardBatchSize,
+		cfg.perfdataForwardBufferSize != w.cfg.perfdataForwardBufferSize,
+		cfg.perfdataForwardInterval != w.cfg.perfdataForwardInterval:
+		restartRequired = true
+	case cfg.cgroupPath != w.cfg.cgroupPath,
+		cfg.cgroupMode != w.cfg.cgroupMode,
+		cfg.cgroupMemoryMax != w.cfg.cgroupMemoryMax,
+		cfg.cgroupCPUMax != w.cfg.cgroupCPUMax,
+		cfg.cgroupPidsMax != w.cfg.cgroupPidsMax:
+		restartRequired = true
+	case cfg.traceExporter != w.cfg.traceExporter,
+		cfg.traceSampleRatio != w.cfg.traceSampleRatio:
 		restartRequired = true
 	case cfg.host != w.cfg.host:
 		restartRequired = true
@@ -295,6 +370,10 @@ func (w *mainWorker) applyConfigChanges() (restartRequired bool, cfg *config) {
 		restartRequired = true
 	case cfg.notifications != w.cfg.notifications:
 		restartRequired = true
+	case strings.Join(cfg.queueLimits, "\n") != strings.Join(w.cfg.queueLimits, "\n"),
+		strings.Join(cfg.workerPoolSettings, "\n") != strings.Join(w.cfg.workerPoolSettings, "\n"),
+		cfg.jobsPerConnection != w.cfg.jobsPerConnection:
+		restartRequired = true
 	}
 
 	// reopen logfile
@@ -315,7 +394,7 @@ func (w *mainWorker) applyConfigChanges() (restartRequired bool, cfg *config) {
 
 // reads the avg loads from /procs/loadavg
 func (w *mainWorker) updateLoadAvg() {
-	if w.cfg.loadLimit1 <= 0 && w.cfg.loadLimit5 <= 0 && w.cfg.loadLimit15 <= 0 {
+	if !w.cfg.hasLoadLimits() {
 		return
 	}
 	file, err := os.Open("/proc/loadavg")
@@ -329,35 +408,43 @@ func (w *mainWorker) updateLoadAvg() {
 	values := strings.Fields(firstline)
 
 	if len(values) >= 3 {
+		w.stateLock.Lock()
 		w.min1 = getFloat(values[0])
 		w.min5 = getFloat(values[1])
 		w.min15 = getFloat(values[2])
+		w.stateLock.Unlock()
 	}
 	file.Close()
 }
 
 // checks all the load limits, if values are set
 func (w *mainWorker) checkLoads() (ok bool, reason string) {
-	if w.cfg.loadLimit1 <= 0 && w.cfg.loadLimit5 <= 0 && w.cfg.loadLimit15 <= 0 {
+	return w.checkLoadLimits(w.cfg.loadLimit1, w.cfg.loadLimit5, w.cfg.loadLimit15)
+}
+
+// checks the given load limits, if values are set
+func (w *mainWorker) checkLoadLimits(loadLimit1, loadLimit5, loadLimit15 float64) (ok bool, reason string) {
+	if loadLimit1 <= 0 && loadLimit5 <= 0 && loadLimit15 <= 0 {
 		return true, ""
 	}
 
-	if w.cfg.loadLimit1 > 0 && w.min1 > 0 && w.cfg.loadLimit1 < w.min1 {
-		reason = fmt.Sprintf("cannot start any more worker, load1 is too high: %f > %f", w.min1, w.cfg.loadLimit1)
+	min1, min5, min15 := w.loadAvg()
+	if loadLimit1 > 0 && min1 > 0 && loadLimit1 < min1 {
+		reason = fmt.Sprintf("cannot start any more worker, load1 is too high: %f > %f", min1, loadLimit1)
 		log.Debug(reason)
 
 		return false, reason
 	}
 
-	if w.cfg.loadLimit5 > 0 && w.min5 > 0 && w.cfg.loadLimit5 < w.min5 {
-		reason = fmt.Sprintf("cannot start any more worker, load5 is too high: %f > %f", w.min5, w.cfg.loadLimit5)
+	if loadLimit5 > 0 && min5 > 0 && loadLimit5 < min5 {
+		reason = fmt.Sprintf("cannot start any more worker, load5 is too high: %f > %f", min5, loadLimit5)
 		log.Debug(reason)
 
 		return false, reason
 	}
 
-	if w.cfg.loadLimit15 > 0 && w.min15 > 0 && w.cfg.loadLimit15 < w.min15 {
-		reason = fmt.Sprintf("cannot start any more worker, load15 is too high: %f > %f", w.min15, w.cfg.loadLimit15)
+	if loadLimit15 > 0 && min15 > 0 && loadLimit15 < min15 {
+		reason = fmt.Sprintf("cannot start any more worker, load15 is too high: %f > %f", min15, loadLimit15)
 		log.Debug(reason)
 
 		return false, reason
@@ -377,25 +464,25 @@ func (w *mainWorker) updateMemInfo() {
 	}
 	scanner := bufio.NewScanner(file)
 	numLines := 3 // number of lines we are interested in
-	w.memFree = 0
-	w.memTotal = 0
+	memFree := uint64(0)
+	memTotal := uint64(0)
 	for scanner.Scan() && numLines > 0 {
 		switch {
 		// use MemFree as fallback, MemAvailable is the value we want to use
 		case bytes.HasPrefix(scanner.Bytes(), []byte(`MemFree:`)):
 			values := strings.Fields(scanner.Text())
-			if len(values) >= 1 && w.memFree == 0 {
-				w.memFree = uint64(getFloat(values[1]))
+			if len(values) >= 1 && memFree == 0 {
+				memFree = uint64(getFloat(values[1]))
 			}
 		case bytes.HasPrefix(scanner.Bytes(), []byte(`MemAvailable:`)):
 			values := strings.Fields(scanner.Text())
 			if len(values) >= 1 {
-				w.memFree = uint64(getFloat(values[1]))
+				memFree = uint64(getFloat(values[1]))
 			}
 		case bytes.HasPrefix(scanner.Bytes(), []byte(`MemTotal:`)):
 			values := strings.Fields(scanner.Text())
 			if len(values) >= 1 {
-				w.memTotal = uint64(getFloat(values[1]))
+				memTotal = uint64(getFloat(values[1]))
 			}
 		default:
 			continue
@@ -403,6 +490,11 @@ func (w *mainWorker) updateMemInfo() {
 		numLines--
 	}
 	file.Close()
+
+	w.stateLock.Lock()
+	w.memFree = memFree
+	w.memTotal = memTotal
+	w.stateLock.Unlock()
 }
 
 // checks the memory threshold in percent
@@ -411,17 +503,18 @@ func (w *mainWorker) checkMemory() (ok bool, reason string) {
 		return true, ""
 	}
 
-	if w.memTotal <= 0 {
+	memTotal, memFree := w.memInfo()
+	if memTotal <= 0 {
 		return true, ""
 	}
 
-	usedPercent := 100 - (w.memFree*100)/w.memTotal
+	usedPercent := 100 - (memFree*100)/memTotal
 	if w.cfg.memLimit > 0 && usedPercent >= w.cfg.memLimit {
 		reason := fmt.Sprintf(
 			"cannot start any more worker, memory usage is too high: %d%% > %d%% (free: %s)",
 			usedPercent,
 			w.cfg.memLimit,
-			bytes2Human(w.memFree*1024),
+			bytes2Human(memFree*1024),
 		)
 		log.Debug(reason)
 
@@ -431,6 +524,47 @@ func (w *mainWorker) checkMemory() (ok bool, reason string) {
 	return true, ""
 }
 
+// loadAvg returns the last load average values
+func (w *mainWorker) loadAvg() (min1, min5, min15 float64) {
+	w.stateLock.RLock()
+	defer w.stateLock.RUnlock()
+
+	return w.min1, w.min5, w.min15
+}
+
+// memInfo returns the last total and free memory in kB
+func (w *mainWorker) memInfo() (total, free uint64) {
+	w.stateLock.RLock()
+	defer w.stateLock.RUnlock()
+
+	return w.memTotal, w.memFree
+}
+
+// resetIdleSince is called whenever new worker have been started
+func (w *mainWorker) resetIdleSince() {
+	w.stateLock.Lock()
+	w.idleSince = time.Now()
+	w.stateLock.Unlock()
+}
+
+func (w *mainWorker) getIdleSince() time.Time {
+	w.stateLock.RLock()
+	defer w.stateLock.RUnlock()
+
+	return w.idleSince
+}
+
+// changeBallooningWorker adjusts the number of backgrounded jobs
+func (w *mainWorker) changeBallooningWorker(delta int) {
+	num := w.curBallooningWorker.Add(int64(delta))
+	ballooningWorkerCount.Set(float64(num))
+}
+
+// numBallooningWorker returns the number of backgrounded jobs
+func (w *mainWorker) numBallooningWorker() int {
+	return int(w.curBallooningWorker.Load())
+}
+
 func (w *mainWorker) unregisterWorker(worker *worker) {
 	switch worker.what {
 	case "check":
@@ -543,6 +677,10 @@ func (w *mainWorker) Shutdown(exitState MainStateType) {
 	}
 	terminateDupServerConsumers()
 	terminateResultServerConsumers()
+	terminateResultSinks()
+	terminatePerfdataForwarders()
+	terminateTracing()
+	terminateCgroups()
 }
 
 // StopAllWorker stops all check worker and the status worker
@@ -36,6 +36,9 @@ const (
 	// (1 gearman connection, 2 fifo pipes for stderr/stdout, one on /dev/null, one sparse)
 	OpenFilesPerWorker = 5
 
+	// OpenFilesPerJob sets the expected number of file handles for each additional job if jobs_per_connection is set
+	OpenFilesPerJob = 4
+
 	// OpenFilesExtraPercent adds 30% safety level when calculating required open files
 	OpenFilesExtraPercent = 1.2
 
@@ -81,7 +84,7 @@ func init() {
 		`[%{ShortFile}:%{Line}] %{Message}`
 }
 
-var log = factorlog.New(os.Stdout, factorlog.NewStdFormatter(LogFormat))
+var log = factorlog.New(os.Stdout, newTextLogFormatter(LogFormat))
 
 var (
 	prometheusListener net.Listener
@@ -131,12 +134,14 @@ func Worker(build string) {
 		}
 	}()
 
-	// initialize prometheus
+	// initialize prometheus and status api
 	prometheusListener = startPrometheus(config)
+	statusListener = startStatusServer(config)
 	defer func() {
 		if prometheusListener != nil {
 			prometheusListener.Close()
 		}
+		stopStatusServer(statusListener)
 		log.Infof("mod-gearman-worker-go shutdown complete")
 	}()
 
@@ -178,14 +183,15 @@ func mainLoop(cfg *config, osSignalChan chan os.Signal, workerMap map[string]*wo
 	log.Infof("%s - version %s (Build: %s) starting with %d workers (max %d), pid: %d (max open files: %d)\n",
 		cfg.binary, VERSION, cfg.build, cfg.minWorker, cfg.maxWorker, os.Getpid(), maxOpenFiles)
 
-	expectedOpenFiles := uint64(float64((cfg.maxWorker*OpenFilesPerWorker + OpenFilesBase)) * OpenFilesExtraPercent)
-	maxPossibleWorker := int(((float64(maxOpenFiles) / OpenFilesExtraPercent) - OpenFilesBase) / OpenFilesPerWorker)
+	openFilesPerWorker := OpenFilesPerWorker + (cfg.maxJobsPerConnection()-1)*OpenFilesPerJob
+	expectedOpenFiles := uint64(float64((cfg.totalMaxWorker()*openFilesPerWorker + OpenFilesBase)) * OpenFilesExtraPercent)
+	maxPossibleWorker := int(((float64(maxOpenFiles) / OpenFilesExtraPercent) - OpenFilesBase) / float64(openFilesPerWorker))
 	if expectedOpenFiles > maxOpenFiles {
-		preMaxWorker := cfg.maxWorker
-		cfg.maxWorker = maxPossibleWorker
-		log.Warnf("current max worker setting (%d) requires open files ulimit of at least %d, current value is %d.",
+		preMaxWorker := cfg.totalMaxWorker()
+		cfg.limitMaxWorker(maxPossibleWorker)
+		log.Warnf("current max worker setting (%d over all pools) requires open files ulimit of at least %d, current value is %d.",
 			preMaxWorker, expectedOpenFiles, maxOpenFiles)
-		log.Warnf("Setting max worker limit to %d", cfg.maxWorker)
+		log.Warnf("Setting max worker limit to %d over all pools", cfg.totalMaxWorker())
 	}
 
 	// initialize epn sub server
@@ -196,7 +202,11 @@ func mainLoop(cfg *config, osSignalChan chan os.Signal, workerMap map[string]*wo
 	mainworker.running = true
 	mainworker.maxOpenFiles = maxOpenFiles
 	mainworker.maxPossibleWorker = maxPossibleWorker
-	defer func() { mainworker.running = false }()
+	currentMainWorker.Store(mainworker)
+	defer func() {
+		mainworker.running = false
+		currentMainWorker.CompareAndSwap(mainworker, nil)
+	}()
 	mainLoopExited := make(chan bool)
 
 	// check connections
@@ -210,13 +220,31 @@ func mainLoop(cfg *config, osSignalChan chan os.Signal, workerMap map[string]*wo
 		}
 	}()
 
-	// just wait till someone hits ctrl+c or we have to reload
+	// query gearmand queue status for adaptive worker scaling
+	if cfg.queueScaling {
+		go mainworker.runQueueScaling()
+	}
+
+	// just wait till someone hits ctrl+c, we have to reload or the worker has been drained
 	mainworker.manageWorkers(initStart)
 	adjustWorkerTicker := time.NewTicker(1 * time.Second)
 	printStatsTicker := time.NewTicker(1 * time.Minute)
 	statsTime := time.Now()
 	lastJobs := float64(0)
 	lastReasonPrinted := false
+
+	// stop worker in background, so we can continue listening to signals
+	stopMainLoop := func() {
+		atomic.StoreInt64(&aIsRunning, 0)
+		numWorker = len(workerMap)
+		adjustWorkerTicker.Stop()
+		printStatsTicker.Stop()
+		go func() {
+			defer logPanicExit()
+			mainworker.Shutdown(exit)
+			mainLoopExited <- true
+		}()
+	}
 	for {
 		select {
 		case <-adjustWorkerTicker.C:
@@ -234,13 +262,18 @@ func mainLoop(cfg *config, osSignalChan chan os.Signal, workerMap map[string]*wo
 			delta := jobs - lastJobs
 			seconds := time.Since(statsTime).Seconds()
 			if seconds > 0 {
+				pressure := ""
+				if status := mainworker.pressureStatus(); status != "" {
+					pressure = " | psi: " + status
+				}
 				log.Infof(
-					"stats: workers: %4d/%-4d busy: %4d | jobs: %7d | current job rate: %7.2f/s",
+					"stats: workers: %4d/%-4d busy: %4d | jobs: %7d | current job rate: %7.2f/s%s",
 					len(mainworker.workerMap),
 					cfg.maxWorker,
-					mainworker.activeWorkers,
+					mainworker.activeWorkers.Load(),
 					int64(delta),
 					delta/seconds,
+					pressure,
 				)
 			}
 			lastJobs = jobs
@@ -261,19 +294,13 @@ func mainLoop(cfg *config, osSignalChan chan os.Signal, workerMap map[string]*wo
 
 				fallthrough
 			case Shutdown, ShutdownGraceFully:
-				atomic.StoreInt64(&aIsRunning, 0)
-				numWorker = len(workerMap)
-				adjustWorkerTicker.Stop()
-				printStatsTicker.Stop()
-				// stop worker in background, so we can continue listening to signals
-				go func() {
-					defer logPanicExit()
-					mainworker.Shutdown(exit)
-					mainLoopExited <- true
-				}()
+				stopMainLoop()
 				// continue waiting for signals or an exited mainLoop
 				continue
 			}
+		case <-mainworker.drainExit:
+			exit = ShutdownGraceFully
+			stopMainLoop()
 		case <-mainLoopExited:
 			// only restart those who have exited in time
 			numWorker -= len(workerMap)
@@ -370,6 +397,78 @@ func checkForReasonableConfig(config *config) error {
 	if config.encryption && config.key == "" && config.keyfile == "" {
 		return fmt.Errorf("encryption enabled but no keys defined")
 	}
+	if config.jobsPerConnection < 1 {
+		return fmt.Errorf("jobs_per_connection must be at least 1")
+	}
+	if config.queueScaling && config.queueScalingInterval <= 0 {
+		return fmt.Errorf("queue_scaling_interval must be greater than zero")
+	}
+	if config.maxOutputSize > 0 && config.maxOutputSize <= len(outputTruncatedMarker) {
+		return fmt.Errorf("max_output_size must be 0 or greater than %d bytes", len(outputTruncatedMarker))
+	}
+
+	if err := checkWorkerPools(config); err != nil {
+		return err
+	}
+
+	if err := checkPsiConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkCgroupConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkRlimitConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkRunAsConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkSandboxConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkResultCacheConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkPerfdataConfig(config); err != nil {
+		return err
+	}
+
+	if err := checkPerfdataMetricsConfig(config); err != nil {
+		return err
+	}
+
+	for _, definition := range config.resultSinks {
+		if _, err := newResultSink(definition); err != nil {
+			return err
+		}
+	}
+
+	for _, definition := range config.perfdataForward {
+		if _, err := newPerfdataWriter(definition, config.perfdataForwardPrefix); err != nil {
+			return err
+		}
+	}
+
+	if config.traceExporter != "" {
+		if _, _, err := parseTraceExporter(config.traceExporter); err != nil {
+			return err
+		}
+	}
+
+	switch config.dupServerBacklogOverflow {
+	case backlogOverflowDropOldest, backlogOverflowDropNewest, backlogOverflowBlock:
+	default:
+		return fmt.Errorf("unknown dupserver_backlog_overflow policy: %s", config.dupServerBacklogOverflow)
+	}
+	if config.dupServerBacklogOverflow == backlogOverflowBlock && config.dupServerBacklogDir == "" {
+		return fmt.Errorf("dupserver_backlog_overflow=block requires dupserver_backlog_dir")
+	}
 
 	if config.minWorker > config.maxWorker {
 		config.maxWorker = config.minWorker
@@ -465,13 +564,19 @@ Mod-Gearman worker executes host- and servicechecks.
 
 Basic Settings:
        --debug=<lvl>
-       --logmode=<automatic|stdout|syslog|file>
+       --logmode=<automatic|stdout|syslog|journald|file>
        --logfile=<path>
+       --logformat=<text|json>
+       --syslog_facility=<facility>
+       --syslog_address=<unix:///dev/log|udp://host:port>
+       --syslog_tag=<tag>
        --debug-result
        --help|-h
        --config=<configfile>
        --server=<server>
        --dupserver=<server>
+       --status_server=<listen address>
+       --status_admin=<yes|no>
 	   -d / --daemon : Turns on the daemon mode. A second process will serve requests while the main process exits after starting it.
 
 Encryption:
@@ -486,13 +591,20 @@ Job Control:
        --notifications
        --hostgroup=<name>
        --servicegroup=<name>
+       --queue_limit=<queue>:<min>[:<max>]
+       --pool.<name>.<option>=<value>
        --max-age=<sec>
+       --check_smoothing_window=<sec>
        --job_timeout=<sec>
+       --jobs_per_connection=<nr>
 
 Worker Control:
        --min-worker=<nr>
        --max-worker=<nr>
        --idle-timeout=<nr>
+       --queue_scaling=<yes|no>
+       --queue_scaling_interval=<sec>
+       --drain_exit=<yes|no>
        --max-jobs=<nr>
        --spawn-rate=<nr>
        --backgrounding-threshold=<sec>
@@ -500,7 +612,31 @@ Worker Control:
        --load_limit5=load5
        --load_limit15=load15
        --mem_limit=<percent>
+       --psi_limit=<cpu|memory|io>.<some|full>.<avg10|avg60|avg300>=<percent>
        --show_error_output
+       --max_output_size=<bytes>
+       --result_cache=<path prefix>:<ttl>
+       --perfdata_validation=<off|log|normalize>
+       --perfdata_metrics=<yes|no>
+       --perfdata_metrics_max_series=<nr>
+       --perfdata_metrics_max_age=<sec>
+       --perfdata_metrics_allow=<regex>
+       --perfdata_metrics_deny=<regex>
+       --perfdata_forward=<influxdb+http|influxdb+https|influxdb+udp|graphite+tcp>://<target>
+       --perfdata_forward_prefix=<name>
+       --perfdata_forward_batch_size=<nr>
+       --perfdata_forward_buffer_size=<nr>
+       --perfdata_forward_flush_interval=<sec>
+
+Plugin Isolation:
+       --cgroup_path=<path>
+       --cgroup_mode=<plugin|pool>
+       --cgroup_memory_max=<bytes>
+       --cgroup_cpu_max=<quota period>
+       --cgroup_pids_max=<nr>
+       --rlimit=[<path prefix>:]<cpu|as|nofile|nproc|core>=<value>
+       --run_as=<path prefix|queue>:<user>[:<group>[:<groups>]]
+       --sandbox=<path prefix>[:no_network]
 
 Embedded Perl:
        --enable_embedded_perl=<yes|no>
@@ -512,6 +648,8 @@ Worker Development:
        --debug-profiler=<listen address>
        --cpuprofile=<file>
        --memprofile=<file>
+       --trace_exporter=<http://host:port|file:///path>
+       --trace_sample_ratio=<ratio>
 
 Miscellaneous:
        --workaround_rc_25
@@ -15,6 +15,8 @@ import (
 func setupUsrSignalChannel(osSignalUsrChannel chan os.Signal) {
 	signal.Notify(osSignalUsrChannel, syscall.SIGUSR1)
 	signal.Notify(osSignalUsrChannel, syscall.SIGUSR2)
+	signal.Notify(osSignalUsrChannel, syscall.SIGTTOU)
+	signal.Notify(osSignalUsrChannel, syscall.SIGTTIN)
 }
 
 func mainSignalHandler(sig os.Signal, config *config) MainStateType {
@@ -53,6 +55,20 @@ func mainSignalHandler(sig os.Signal, config *config) MainStateType {
 		}
 		log.Warnf("memory profile written to: %s", config.flagMemProfile)
 
+		return Resume
+	case syscall.SIGTTOU:
+		log.Infof("got sigttou, draining worker")
+		if mainworker := currentMainWorker.Load(); mainworker != nil {
+			mainworker.startDrain(mainworker.cfg.drainExit)
+		}
+
+		return Resume
+	case syscall.SIGTTIN:
+		log.Infof("got sigttin, leaving drain mode")
+		if mainworker := currentMainWorker.Load(); mainworker != nil {
+			mainworker.stopDrain()
+		}
+
 		return Resume
 	default:
 		log.Warnf("Signal not handled: %v", sig)
@@ -68,6 +84,21 @@ func setSysProcAttr(cmd *exec.Cmd) {
 	}
 }
 
+// setSysProcCredential runs the command as different user, only possible when running as root
+func setSysProcCredential(cmd *exec.Cmd, rule *runAsRule) {
+	if os.Geteuid() != 0 {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    rule.uid,
+		Gid:    rule.gid,
+		Groups: rule.groups,
+	}
+}
+
 func processTimeoutKill(proc *os.Process) {
 	go func(pid int) {
 		defer logPanicExit()
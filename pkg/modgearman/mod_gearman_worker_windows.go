@@ -38,6 +38,10 @@ func setSysProcAttr(_ *exec.Cmd) {
 	// not supported on windows
 }
 
+func setSysProcCredential(_ *exec.Cmd, _ *runAsRule) {
+	// not supported on windows
+}
+
 func processTimeoutKill(p *os.Process) {
 	logDebug(p.Kill())
 }
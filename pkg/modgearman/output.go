@@ -0,0 +1,100 @@
+package modgearman
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// outputTruncatedMarker is inserted where the plugin output has been cut
+const outputTruncatedMarker = "\n[output truncated by worker]"
+
+// limitedBuffer is a io.Writer which keeps the first max bytes and a short tail of the written data
+type limitedBuffer struct {
+	max  int
+	head []byte
+	tail []byte
+	size int
+}
+
+func newLimitedBuffer(maxSize int) *limitedBuffer {
+	return &limitedBuffer{max: maxSize}
+}
+
+func (buf *limitedBuffer) Write(data []byte) (int, error) {
+	written := len(data)
+	buf.size += written
+	if buf.max <= 0 {
+		buf.head = append(buf.head, data...)
+
+		return written, nil
+	}
+
+	if room := buf.max - len(buf.head); room > 0 {
+		num := min(room, len(data))
+		buf.head = append(buf.head, data[:num]...)
+		data = data[num:]
+	}
+
+	// keep the tail, it usually contains the performance data
+	if len(data) > 0 {
+		buf.tail = append(buf.tail, data...)
+		if keep := buf.max / 2; len(buf.tail) > keep {
+			buf.tail = buf.tail[len(buf.tail)-keep:]
+		}
+	}
+
+	return written, nil
+}
+
+// Bytes returns the head and the tail of the written data
+func (buf *limitedBuffer) Bytes() []byte {
+	if len(buf.tail) == 0 {
+		return buf.head
+	}
+	data := make([]byte, 0, len(buf.head)+len(buf.tail))
+	data = append(data, buf.head...)
+
+	return append(data, buf.tail...)
+}
+
+// truncateOutput shortens the output to maxSize bytes and keeps the trailing performance data if possible
+func truncateOutput(output string, maxSize int) (string, bool) {
+	if maxSize <= 0 || len(output) <= maxSize {
+		return output, false
+	}
+	if maxSize <= len(outputTruncatedMarker) {
+		return output[:utf8Boundary(output, maxSize)], true
+	}
+
+	// perfdata is dropped unless it fits next to the marker
+	perfdata := ""
+	if idx := strings.LastIndex(output, "|"); idx >= 0 && len(output)-idx <= maxSize/2 {
+		perfdata = output[idx:]
+	}
+	if len(outputTruncatedMarker)+len(perfdata) >= maxSize {
+		perfdata = ""
+	}
+	cut := utf8Boundary(output, max(maxSize-len(outputTruncatedMarker)-len(perfdata), 0))
+
+	return output[:cut] + outputTruncatedMarker + perfdata, true
+}
+
+// utf8Boundary returns the largest position <= pos which does not split a multibyte character
+func utf8Boundary(text string, pos int) int {
+	for pos > 0 && !utf8.RuneStart(text[pos]) {
+		pos--
+	}
+
+	return pos
+}
+
+// limitOutput truncates the plugin output to max_output_size
+func limitOutput(result *answer, config *config, received *request) {
+	output, truncated := truncateOutput(result.output, config.maxOutputSize)
+	if !truncated {
+		return
+	}
+	log.Debugf("output of %s truncated from %d to %d bytes", received.commandLine, len(result.output), len(output))
+	result.output = output
+	outputTruncatedCounter.WithLabelValues(received.typ, result.execType).Inc()
+}
@@ -0,0 +1,102 @@
+package modgearman
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	buf := newLimitedBuffer(10)
+	for range 100 {
+		_, err := buf.Write([]byte("0123456789"))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1000, buf.size)
+	assert.Equal(t, "012345678956789", string(buf.Bytes()))
+
+	buf = newLimitedBuffer(0)
+	_, err := buf.Write([]byte(strings.Repeat("x", 1000)))
+	require.NoError(t, err)
+	assert.Len(t, buf.Bytes(), 1000)
+}
+
+func TestTruncateOutput(t *testing.T) {
+	output, truncated := truncateOutput("OK - fine|a=1", 100)
+	assert.False(t, truncated)
+	assert.Equal(t, "OK - fine|a=1", output)
+
+	output, truncated = truncateOutput("OK - fine|a=1", 0)
+	assert.False(t, truncated)
+	assert.Equal(t, "OK - fine|a=1", output)
+
+	// perfdata from the long output is kept
+	output, truncated = truncateOutput("OK - fine\n"+strings.Repeat("x", 1000)+"|a=1 b=2", 100)
+	assert.True(t, truncated)
+	assert.Len(t, output, 100)
+	assert.True(t, strings.HasPrefix(output, "OK - fine\nxxx"))
+	assert.True(t, strings.HasSuffix(output, outputTruncatedMarker+"|a=1 b=2"))
+
+	// perfdata in the first line is kept as part of the head
+	output, truncated = truncateOutput("OK - fine|a=1\n"+strings.Repeat("x", 1000), 100)
+	assert.True(t, truncated)
+	assert.Len(t, output, 100)
+	assert.True(t, strings.HasPrefix(output, "OK - fine|a=1\nxxx"))
+	assert.True(t, strings.HasSuffix(output, "x"+outputTruncatedMarker))
+
+	// multibyte characters are not split
+	output, truncated = truncateOutput(strings.Repeat("ä", 100), 51)
+	assert.True(t, truncated)
+	assert.Equal(t, strings.Repeat("ä", 11)+outputTruncatedMarker, output)
+
+	output, truncated = truncateOutput(strings.Repeat("x", 100), 10)
+	assert.True(t, truncated)
+	assert.Equal(t, strings.Repeat("x", 10), output)
+
+	// perfdata which does not fit next to the marker is dropped
+	for _, maxSize := range []int{30, 40, 44} {
+		output, truncated = truncateOutput(strings.Repeat("x", 100)+"|perf=1;2;3;4;5", maxSize)
+		assert.True(t, truncated)
+		assert.LessOrEqualf(t, len(output), maxSize, "max size: %d", maxSize)
+		assert.Truef(t, strings.HasSuffix(output, outputTruncatedMarker), "max size: %d", maxSize)
+	}
+	output, _ = truncateOutput(strings.Repeat("x", 100)+"|perf=1;2;3;4;5", 60)
+	assert.Equal(t, strings.Repeat("x", 16)+outputTruncatedMarker+"|perf=1;2;3;4;5", output)
+}
+
+func TestExecuteOutputLimit(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.maxOutputSize = 200
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'echo OK; seq 1 100000; echo \"|a=1\"'", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Len(t, strings.ReplaceAll(result.output, `\n`, "\n"), 200)
+	assert.True(t, strings.HasPrefix(result.output, `OK\n1\n2\n`))
+	assert.True(t, strings.HasSuffix(result.output, `\n[output truncated by worker]|a=1`))
+
+	cfg.internalCheckDummy = true
+	result = readAndExecute(&request{typ: "service", commandLine: "/usr/lib/check_dummy 0 '" + strings.Repeat("x", 500) + "'", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Len(t, result.output, 200)
+	assert.Contains(t, result.output, outputTruncatedMarker)
+}
+
+func TestMaxOutputSizeConfig(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.server = []string{"localhost:4730"}
+	cfg.encryption = false
+	cfg.services = true
+
+	cfg.maxOutputSize = 20
+	require.Error(t, checkForReasonableConfig(&cfg))
+
+	cfg.maxOutputSize = 0
+	require.NoError(t, checkForReasonableConfig(&cfg))
+
+	cfg.maxOutputSize = 100
+	require.NoError(t, checkForReasonableConfig(&cfg))
+}
@@ -0,0 +1,366 @@
+package modgearman
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// possible values for perfdata_validation
+const (
+	perfdataValidationOff       = "off"
+	perfdataValidationLog       = "log"
+	perfdataValidationNormalize = "normalize"
+)
+
+const (
+	perfdataNumber = `[-+]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:[eE][-+]?[0-9]+)?`
+
+	// values are accepted with decimal comma as well, but reported as problem
+	perfdataValueNumber = `[-+]?(?:[0-9]+(?:[.,][0-9]*)?|[.,][0-9]+)(?:[eE][-+]?[0-9]+)?`
+)
+
+var (
+	rePerfdataValue    = regexp.MustCompile(`^(` + perfdataValueNumber + `|U)(.*)$`)
+	rePerfdataRange    = regexp.MustCompile(`^@?(?:(?:~|` + perfdataNumber + `)?:)?(?:` + perfdataNumber + `)?$`)
+	rePerfdataMinMax   = regexp.MustCompile(`^(?:` + perfdataNumber + `)?$`)
+	validPerfdataUnits = []string{"", "s", "ms", "us", "%", "B", "KB", "MB", "GB", "TB", "c"}
+	perfdataFieldNames = []string{"warning threshold", "critical threshold", "min", "max"}
+)
+
+// pluginOutput contains the parsed output of a nagios plugin
+type pluginOutput struct {
+	text         string           // first line without perfdata
+	perfdata     []*perfdataValue // perfdata from the first line
+	longOutput   string           // remaining lines without perfdata
+	longPerfdata []*perfdataValue // perfdata following the | in the long output
+}
+
+// perfdataValue is a single performance data item: 'label'=value[uom];[warn];[crit];[min];[max]
+type perfdataValue struct {
+	raw      string
+	label    string
+	value    string
+	uom      string
+	fields   []string // warn, crit, min and max
+	invalid  bool     // label or value cannot be parsed
+	problems []string
+}
+
+// parsePluginOutput splits the plugin output into text, long output and perfdata
+func parsePluginOutput(output string) *pluginOutput {
+	parsed := &pluginOutput{}
+	firstLine, rest, _ := strings.Cut(output, "\n")
+
+	text, perf, _ := strings.Cut(firstLine, "|")
+	parsed.text = text
+	parsed.perfdata = parsePerfdata(perf)
+
+	longOutput, longPerf, _ := strings.Cut(rest, "|")
+	parsed.longOutput = longOutput
+	parsed.longPerfdata = parsePerfdata(longPerf)
+
+	return parsed
+}
+
+// String returns the plugin output in nagios plugin format
+func (out *pluginOutput) String() string {
+	var output strings.Builder
+	output.WriteString(out.text)
+	if len(out.perfdata) > 0 {
+		output.WriteString("|")
+		output.WriteString(joinPerfdata(out.perfdata))
+	}
+	if out.longOutput != "" || len(out.longPerfdata) > 0 {
+		output.WriteString("\n")
+		output.WriteString(out.longOutput)
+	}
+	if len(out.longPerfdata) > 0 {
+		output.WriteString("|")
+		output.WriteString(joinPerfdata(out.longPerfdata))
+	}
+
+	return output.String()
+}
+
+// problems returns all perfdata validation errors
+func (out *pluginOutput) problems() []string {
+	problems := []string{}
+	for _, value := range out.perfdata {
+		problems = append(problems, value.problems...)
+	}
+	for _, value := range out.longPerfdata {
+		problems = append(problems, value.problems...)
+	}
+
+	return problems
+}
+
+// normalize removes unparsable perfdata and strips invalid units and thresholds
+func (out *pluginOutput) normalize() {
+	out.perfdata = normalizePerfdata(out.perfdata)
+	out.longPerfdata = normalizePerfdata(out.longPerfdata)
+}
+
+func normalizePerfdata(values []*perfdataValue) []*perfdataValue {
+	normalized := make([]*perfdataValue, 0, len(values))
+	for _, value := range values {
+		if value.invalid {
+			continue
+		}
+		value.value = strings.Replace(value.value, ",", ".", 1)
+		if !isValidPerfdataUnit(value.uom) {
+			value.uom = ""
+		}
+		for i, field := range value.fields {
+			if !isValidPerfdataField(i, field) {
+				value.fields[i] = ""
+			}
+		}
+		normalized = append(normalized, value)
+	}
+
+	return normalized
+}
+
+func joinPerfdata(values []*perfdataValue) string {
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		list = append(list, value.String())
+	}
+
+	return strings.Join(list, " ")
+}
+
+// parsePerfdata splits the perfdata string into its values, labels may be quoted by single quotes
+func parsePerfdata(perf string) []*perfdataValue {
+	values := []*perfdataValue{}
+	pending := "" // unquoted words without value, ex. labels containing spaces
+	for {
+		perf = strings.TrimLeftFunc(perf, unicode.IsSpace)
+		if perf == "" {
+			break
+		}
+
+		var token string
+		token, perf = nextPerfdataToken(perf)
+		if !strings.HasPrefix(token, "'") && !strings.Contains(token, "=") {
+			pending += token + " "
+
+			continue
+		}
+
+		if pending != "" && strings.HasPrefix(token, "'") {
+			values = append(values, newInvalidPerfdataValue(strings.TrimSpace(pending), "missing value"))
+			pending = ""
+		}
+		value := parsePerfdataValue(pending + token)
+		if pending != "" && !value.invalid {
+			value.problems = append(value.problems, fmt.Sprintf("%s: label containing spaces must be quoted", value.label))
+		}
+		pending = ""
+		values = append(values, value)
+	}
+	if pending != "" {
+		values = append(values, newInvalidPerfdataValue(strings.TrimSpace(pending), "missing value"))
+	}
+
+	return values
+}
+
+// nextPerfdataToken returns the next whitespace separated token and the remaining string
+func nextPerfdataToken(perf string) (token, rest string) {
+	start := 0
+	if strings.HasPrefix(perf, "'") {
+		start = quotedLabelEnd(perf)
+		if start == -1 {
+			return perf, ""
+		}
+	}
+	end := strings.IndexFunc(perf[start:], unicode.IsSpace)
+	if end == -1 {
+		return perf, ""
+	}
+
+	return perf[:start+end], perf[start+end:]
+}
+
+// quotedLabelEnd returns the position after the closing quote, two single quotes are an escaped quote
+func quotedLabelEnd(perf string) int {
+	pos := 1
+	for {
+		idx := strings.IndexByte(perf[pos:], '\'')
+		if idx == -1 {
+			return -1
+		}
+		pos += idx
+		if pos+1 < len(perf) && perf[pos+1] == '\'' {
+			pos += 2
+
+			continue
+		}
+
+		return pos + 1
+	}
+}
+
+func parsePerfdataValue(raw string) *perfdataValue {
+	var label, data string
+	if strings.HasPrefix(raw, "'") {
+		end := quotedLabelEnd(raw)
+		if end == -1 {
+			return newInvalidPerfdataValue(raw, "unterminated quote")
+		}
+		label = strings.ReplaceAll(raw[1:end-1], "''", "'")
+		rest, found := strings.CutPrefix(raw[end:], "=")
+		if !found {
+			return newInvalidPerfdataValue(raw, "missing value")
+		}
+		data = rest
+	} else {
+		var found bool
+		label, data, found = strings.Cut(raw, "=")
+		if !found {
+			return newInvalidPerfdataValue(raw, "missing value")
+		}
+	}
+	if label == "" {
+		return newInvalidPerfdataValue(raw, "empty label")
+	}
+
+	value := &perfdataValue{raw: raw, label: label}
+	if !strings.HasPrefix(raw, "'") && strings.Contains(label, "'") {
+		value.problems = append(value.problems, fmt.Sprintf("%s: label containing quotes must be quoted", label))
+	}
+
+	fields := strings.Split(data, ";")
+	if len(fields) > 5 {
+		value.problems = append(value.problems, fmt.Sprintf("%s: too many fields", label))
+		fields = fields[:5]
+	}
+
+	matches := rePerfdataValue.FindStringSubmatch(fields[0])
+	if matches == nil {
+		value.invalid = true
+		value.problems = append(value.problems, fmt.Sprintf("%s: invalid value %s", label, fields[0]))
+
+		return value
+	}
+	value.value = matches[1]
+	value.uom = matches[2]
+	if strings.Contains(value.value, ",") {
+		value.problems = append(value.problems, fmt.Sprintf("%s: decimal comma in value %s", label, value.value))
+	}
+	if !isValidPerfdataUnit(value.uom) {
+		value.problems = append(value.problems, fmt.Sprintf("%s: invalid uom %s", label, value.uom))
+	}
+
+	value.fields = fields[1:]
+	for i, field := range value.fields {
+		if !isValidPerfdataField(i, field) {
+			value.problems = append(value.problems, fmt.Sprintf("%s: invalid %s %s", label, perfdataFieldNames[i], field))
+		}
+	}
+
+	return value
+}
+
+func newInvalidPerfdataValue(raw, problem string) *perfdataValue {
+	return &perfdataValue{
+		raw:      raw,
+		invalid:  true,
+		problems: []string{fmt.Sprintf("%s: %s", raw, problem)},
+	}
+}
+
+// String returns the perfdata value in nagios plugin format, invalid values are returned unchanged
+func (value *perfdataValue) String() string {
+	if value.invalid {
+		return value.raw
+	}
+
+	label := value.label
+	if strings.ContainsAny(label, "'= \t") {
+		label = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+	}
+	fields := append([]string{value.value + value.uom}, value.fields...)
+
+	return label + "=" + strings.TrimRight(strings.Join(fields, ";"), ";")
+}
+
+func isValidPerfdataUnit(uom string) bool {
+	for _, valid := range validPerfdataUnits {
+		if uom == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isValidPerfdataField validates warn/crit ranges and min/max values
+func isValidPerfdataField(index int, field string) bool {
+	if index < 2 {
+		return rePerfdataRange.MatchString(field)
+	}
+
+	return rePerfdataMinMax.MatchString(field)
+}
+
+// pluginOutput returns the output of the result with real newlines. Only execCmd escapes
+// newlines, the unescaped output is used as long as the output has not been replaced since.
+// Literal backslash-n written by the plugin itself are kept.
+func (a *answer) pluginOutput() (output string, escaped bool) {
+	if a.rawOutput != "" && strings.ReplaceAll(a.rawOutput, "\n", `\n`) == a.output {
+		return a.rawOutput, true
+	}
+
+	return a.output, false
+}
+
+// checkPerfdata validates the perfdata of the plugin result according to perfdata_validation
+func checkPerfdata(result *answer, received *request, config *config) {
+	if config.perfdataValidation == "" || config.perfdataValidation == perfdataValidationOff {
+		return
+	}
+
+	output, escaped := result.pluginOutput()
+	parsed := parsePluginOutput(output)
+	problems := parsed.problems()
+	if len(problems) == 0 {
+		return
+	}
+
+	command := "unknown"
+	if fields := strings.Fields(received.commandLine); len(fields) > 0 {
+		command = filepath.Base(fields[0])
+	}
+	perfdataErrorCounter.WithLabelValues(command).Add(float64(len(problems)))
+
+	switch config.perfdataValidation {
+	case perfdataValidationLog:
+		log.Warnf("malformed perfdata from %s (host: %s, service: %s): %s",
+			command, received.hostName, received.serviceDescription, strings.Join(problems, ", "))
+	case perfdataValidationNormalize:
+		log.Debugf("normalizing malformed perfdata from %s (host: %s, service: %s): %s",
+			command, received.hostName, received.serviceDescription, strings.Join(problems, ", "))
+		parsed.normalize()
+		result.output = parsed.String()
+		if escaped {
+			result.rawOutput = result.output
+			result.output = strings.ReplaceAll(result.output, "\n", `\n`)
+		}
+	}
+}
+
+// checkPerfdataConfig verifies the perfdata_validation option
+func checkPerfdataConfig(config *config) error {
+	switch config.perfdataValidation {
+	case perfdataValidationOff, perfdataValidationLog, perfdataValidationNormalize:
+		return nil
+	default:
+		return fmt.Errorf("invalid perfdata_validation %s, must be one of: off, log, normalize", config.perfdataValidation)
+	}
+}
@@ -0,0 +1,93 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePluginOutput(t *testing.T) {
+	parsed := parsePluginOutput("OK - fine|rta=0.5ms;100;500;0 'packet loss'=0%;20;60\nline 2\nline 3|'it''s'=1 size=12KB;;;0;1024")
+	assert.Equal(t, "OK - fine", parsed.text)
+	assert.Equal(t, "line 2\nline 3", parsed.longOutput)
+	require.Len(t, parsed.perfdata, 2)
+	require.Len(t, parsed.longPerfdata, 2)
+	assert.Empty(t, parsed.problems())
+
+	rta := parsed.perfdata[0]
+	assert.Equal(t, "rta", rta.label)
+	assert.Equal(t, "0.5", rta.value)
+	assert.Equal(t, "ms", rta.uom)
+	assert.Equal(t, []string{"100", "500", "0"}, rta.fields)
+
+	assert.Equal(t, "packet loss", parsed.perfdata[1].label)
+	assert.Equal(t, "%", parsed.perfdata[1].uom)
+	assert.Equal(t, "it's", parsed.longPerfdata[0].label)
+	assert.Equal(t, []string{"", "", "0", "1024"}, parsed.longPerfdata[1].fields)
+
+	assert.Equal(t, "OK - fine|rta=0.5ms;100;500;0 'packet loss'=0%;20;60\nline 2\nline 3|'it''s'=1 size=12KB;;;0;1024", parsed.String())
+
+	parsed = parsePluginOutput("OK - no perfdata")
+	assert.Equal(t, "OK - no perfdata", parsed.text)
+	assert.Empty(t, parsed.perfdata)
+	assert.Equal(t, "OK - no perfdata", parsed.String())
+}
+
+func TestPerfdataValidation(t *testing.T) {
+	tests := []struct {
+		output     string
+		problems   []string
+		normalized string
+	}{
+		{"OK|a=1Kb", []string{"a: invalid uom Kb"}, "OK|a=1"},
+		{"OK|a=1;5:x;10", []string{"a: invalid warning threshold 5:x"}, "OK|a=1;;10"},
+		{"OK|a=1;~:5;@10:20;0;100", nil, "OK|a=1;~:5;@10:20;0;100"},
+		{"OK|a=1,5s", []string{"a: decimal comma in value 1,5"}, "OK|a=1.5s"},
+		{"OK|a=foo b=2", []string{"a: invalid value foo"}, "OK|b=2"},
+		{"OK|packet loss=0%", []string{"packet loss: label containing spaces must be quoted"}, "OK|'packet loss'=0%"},
+		{"OK|it's=1", []string{"it's: label containing quotes must be quoted"}, "OK|'it''s'=1"},
+		{"OK|a=1 b", []string{"b: missing value"}, "OK|a=1"},
+		{"OK|'a=1", []string{"'a=1: unterminated quote"}, "OK"},
+		{"OK|=1", []string{"=1: empty label"}, "OK"},
+		{"OK|a=U;1;2", nil, "OK|a=U;1;2"},
+		{"OK|a=1;1;2;3;4;5", []string{"a: too many fields"}, "OK|a=1;1;2;3;4"},
+	}
+
+	for _, test := range tests {
+		parsed := parsePluginOutput(test.output)
+		if test.problems == nil {
+			test.problems = []string{}
+		}
+		assert.Equalf(t, test.problems, parsed.problems(), "problems in: %s", test.output)
+		parsed.normalize()
+		assert.Equalf(t, test.normalized, parsed.String(), "normalized: %s", test.output)
+	}
+}
+
+func TestCheckPerfdata(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	received := &request{commandLine: "/usr/lib/plugins/check_test -w 1"}
+
+	result := &answer{output: "OK|a=1Kb\nlong output|b=foo"}
+	checkPerfdata(result, received, &cfg)
+	assert.Equal(t, "OK|a=1Kb\nlong output|b=foo", result.output)
+
+	cfg.perfdataValidation = perfdataValidationLog
+	checkPerfdata(result, received, &cfg)
+	assert.Equal(t, "OK|a=1Kb\nlong output|b=foo", result.output)
+
+	cfg.perfdataValidation = perfdataValidationNormalize
+	checkPerfdata(result, received, &cfg)
+	assert.Equal(t, "OK|a=1\nlong output", result.output)
+
+	// newlines escaped by the worker are restored, literal backslash-n written by the plugin are kept
+	result = &answer{rawOutput: "OK - a\\nb|a=1Kb\nlong output|b=foo", output: `OK - a\nb|a=1Kb\nlong output|b=foo`}
+	checkPerfdata(result, received, &cfg)
+	assert.Equal(t, `OK - a\nb|a=1\nlong output`, result.output)
+	assert.Equal(t, "OK - a\\nb|a=1\nlong output", result.rawOutput)
+
+	cfg.perfdataValidation = "fix"
+	require.Error(t, checkPerfdataConfig(&cfg))
+}
@@ -0,0 +1,446 @@
+package modgearman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// perfdataForwardQueueSize sets the queue size for each perfdata forwarder
+	perfdataForwardQueueSize = 1000
+
+	// perfdataForwardTimeout sets the timeout for sending a batch to the backend
+	perfdataForwardTimeout = 10 * time.Second
+
+	// perfdataForwardUDPPacketSize limits the size of influxdb udp packets
+	perfdataForwardUDPPacketSize = 1400
+)
+
+var (
+	reGraphiteInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+	influxTagEscaper       = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasurementEsc   = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// perfdataPoint is a single perfdata value of a check result
+type perfdataPoint struct {
+	hostName           string
+	serviceDescription string
+	label              string
+	uom                string
+	value              float64
+	thresholds         map[string]float64 // numeric warn, crit, min and max
+	timestamp          time.Time
+}
+
+// perfdataWriter sends perfdata points to a time series backend
+type perfdataWriter interface {
+	// Write sends a batch of points
+	Write(points []*perfdataPoint) error
+	// Close releases all resources of the writer
+	Close() error
+}
+
+type perfdataForwarder struct {
+	name               string
+	writer             perfdataWriter
+	queue              chan *answer
+	terminationRequest chan bool
+	buffer             []*perfdataPoint
+	failed             bool // last flush failed, wait for next interval before retrying
+	batchSize          int
+	bufferSize         int
+	flushInterval      time.Duration
+}
+
+var perfdataForwarders []*perfdataForwarder
+
+// newPerfdataWriter creates a perfdata writer from its configuration value
+// supported formats are:
+//   - influxdb+http://host:port/write?db=... or influxdb+https://... (influxdb line protocol over http)
+//   - influxdb+udp://host:port (influxdb line protocol over udp)
+//   - graphite+tcp://host:port (graphite plaintext protocol)
+func newPerfdataWriter(definition, prefix string) (perfdataWriter, error) {
+	scheme, target, ok := strings.Cut(definition, "://")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("invalid perfdata_forward %s, expected <scheme>://<target>", redactURL(definition))
+	}
+
+	switch strings.ToLower(scheme) {
+	case "influxdb+http", "influxdb+https":
+		writeURL, err := url.Parse(strings.TrimPrefix(strings.ToLower(scheme), "influxdb+") + "://" + target)
+		if err != nil {
+			// the url error contains the full url including credentials
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+
+			return nil, fmt.Errorf("invalid perfdata_forward %s: %s", redactURL(definition), err.Error())
+		}
+		writer := &perfdataInfluxHTTP{
+			measurement: prefix,
+			user:        writeURL.User,
+			client:      &http.Client{Timeout: perfdataForwardTimeout},
+		}
+		writeURL.User = nil
+		writer.url = writeURL.String()
+
+		return writer, nil
+	case "influxdb+udp":
+		return &perfdataInfluxUDP{address: target, measurement: prefix}, nil
+	case "graphite+tcp":
+		return &perfdataGraphite{address: target, prefix: prefix}, nil
+	default:
+		return nil, fmt.Errorf("unsupported perfdata_forward scheme %s in %s", scheme, redactURL(definition))
+	}
+}
+
+func initializePerfdataForwarders(config *config) {
+	perfdataForwarders = make([]*perfdataForwarder, 0, len(config.perfdataForward))
+	for _, definition := range config.perfdataForward {
+		writer, err := newPerfdataWriter(definition, config.perfdataForwardPrefix)
+		if err != nil {
+			log.Errorf("perfdata forwarding disabled: %s", err.Error())
+
+			continue
+		}
+		// the name is used as metric label, so it must not contain credentials
+		name := redactURL(definition)
+		log.Debugf("creating perfdata forwarder for: %s", name)
+		forwarder := &perfdataForwarder{
+			name:               name,
+			writer:             writer,
+			queue:              make(chan *answer, perfdataForwardQueueSize),
+			terminationRequest: make(chan bool),
+			batchSize:          max(1, config.perfdataForwardBatchSize),
+			bufferSize:         max(1, config.perfdataForwardBufferSize),
+			flushInterval:      time.Duration(max(1, config.perfdataForwardInterval)) * time.Second,
+		}
+		perfdataForwarders = append(perfdataForwarders, forwarder)
+		go func() {
+			defer logPanicExit()
+			runPerfdataForwarder(forwarder)
+		}()
+	}
+}
+
+func terminatePerfdataForwarders() {
+	log.Debugf("Terminating perfdata forwarders")
+	for _, forwarder := range perfdataForwarders {
+		forwarder.terminationRequest <- true
+	}
+	perfdataForwarders = nil
+}
+
+func runPerfdataForwarder(forwarder *perfdataForwarder) {
+	defer func() {
+		logDebug(forwarder.writer.Close())
+	}()
+	ticker := time.NewTicker(forwarder.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-forwarder.terminationRequest:
+			// flush remaining results
+			for {
+				select {
+				case result := <-forwarder.queue:
+					forwarder.add(result)
+				default:
+					forwarder.flush()
+
+					return
+				}
+			}
+		case result := <-forwarder.queue:
+			forwarder.add(result)
+			if !forwarder.failed && len(forwarder.buffer) >= forwarder.batchSize {
+				forwarder.flush()
+			}
+		case <-ticker.C:
+			forwarder.flush()
+		}
+	}
+}
+
+// add parses the perfdata of the result and appends it to the buffer, oldest points are dropped if the buffer is full
+func (forwarder *perfdataForwarder) add(result *answer) {
+	forwarder.buffer = append(forwarder.buffer, perfdataPoints(result)...)
+	if overflow := len(forwarder.buffer) - forwarder.bufferSize; overflow > 0 {
+		perfdataForwardDroppedCounter.WithLabelValues(forwarder.name).Add(float64(overflow))
+		log.Debugf("perfdata buffer is at capacity (%d), dropping %d point(s): %s", forwarder.bufferSize, overflow, forwarder.name)
+		forwarder.buffer = forwarder.buffer[overflow:]
+	}
+}
+
+// flush sends all buffered points in batches, unsent points are kept for the next try
+func (forwarder *perfdataForwarder) flush() {
+	for len(forwarder.buffer) > 0 {
+		num := min(forwarder.batchSize, len(forwarder.buffer))
+		err := forwarder.writer.Write(forwarder.buffer[:num])
+		if err != nil {
+			forwarder.failed = true
+			perfdataForwardErrorCounter.WithLabelValues(forwarder.name).Inc()
+			log.Debugf("failed to send perfdata to %s: %s", forwarder.name, err.Error())
+
+			return
+		}
+		forwarder.buffer = forwarder.buffer[num:]
+	}
+	forwarder.failed = false
+	forwarder.buffer = nil
+}
+
+// enqueuePerfdataForwarders passes a copy of the result to all configured perfdata forwarders
+func enqueuePerfdataForwarders(result *answer) {
+	if len(perfdataForwarders) == 0 {
+		return
+	}
+	forwardResult := *result
+
+	for _, forwarder := range perfdataForwarders {
+		select {
+		case forwarder.queue <- &forwardResult:
+		default:
+			perfdataForwardDroppedCounter.WithLabelValues(forwarder.name).Inc()
+			log.Debugf("channel is at capacity (%d), dropping message (to perfdata forwarder): %s", perfdataForwardQueueSize, forwarder.name)
+		}
+	}
+}
+
+// perfdataPoints returns all numeric perfdata values of the result
+func perfdataPoints(result *answer) []*perfdataPoint {
+	output, _ := result.pluginOutput()
+	parsed := parsePluginOutput(output)
+	timestamp := time.Now()
+	if result.finishTime > 0 {
+		timestamp = time.Unix(0, int64(result.finishTime*float64(time.Second)))
+	}
+
+	points := []*perfdataPoint{}
+	for _, perf := range append(parsed.perfdata, parsed.longPerfdata...) {
+		if perf.invalid || perf.value == "U" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.Replace(perf.value, ",", ".", 1), 64)
+		if err != nil {
+			continue
+		}
+		point := &perfdataPoint{
+			hostName:           result.hostName,
+			serviceDescription: result.serviceDescription,
+			label:              perf.label,
+			uom:                perf.uom,
+			value:              value,
+			thresholds:         map[string]float64{},
+			timestamp:          timestamp,
+		}
+		for i, name := range []string{"warn", "crit", "min", "max"} {
+			if i >= len(perf.fields) {
+				break
+			}
+			if threshold, err := strconv.ParseFloat(perf.fields[i], 64); err == nil {
+				point.thresholds[name] = threshold
+			}
+		}
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// influxLine returns the point in influxdb line protocol
+func (point *perfdataPoint) influxLine(measurement string) string {
+	var line strings.Builder
+	line.WriteString(influxMeasurementEsc.Replace(measurement))
+	for _, tag := range [][2]string{
+		{"host", point.hostName},
+		{"service", point.serviceDescription},
+		{"label", point.label},
+		{"uom", point.uom},
+	} {
+		// empty tag values are not allowed
+		if tag[1] == "" {
+			continue
+		}
+		line.WriteString("," + tag[0] + "=" + influxTagEscaper.Replace(tag[1]))
+	}
+	line.WriteString(" value=" + strconv.FormatFloat(point.value, 'f', -1, 64))
+	for _, name := range []string{"warn", "crit", "min", "max"} {
+		if threshold, ok := point.thresholds[name]; ok {
+			line.WriteString("," + name + "=" + strconv.FormatFloat(threshold, 'f', -1, 64))
+		}
+	}
+	line.WriteString(" " + strconv.FormatInt(point.timestamp.UnixNano(), 10) + "\n")
+
+	return line.String()
+}
+
+// graphiteLine returns the point in graphite plaintext protocol
+func (point *perfdataPoint) graphiteLine(prefix string) string {
+	path := make([]string, 0, 4)
+	for _, name := range []string{prefix, point.hostName, point.serviceDescription, point.label} {
+		if name != "" {
+			path = append(path, reGraphiteInvalidChars.ReplaceAllString(name, "_"))
+		}
+	}
+
+	return fmt.Sprintf("%s %s %d\n", strings.Join(path, "."), strconv.FormatFloat(point.value, 'f', -1, 64), point.timestamp.Unix())
+}
+
+// perfdataInfluxHTTP posts batches in influxdb line protocol
+type perfdataInfluxHTTP struct {
+	url         string
+	user        *url.Userinfo
+	measurement string
+	client      *http.Client
+}
+
+func (w *perfdataInfluxHTTP) Write(points []*perfdataPoint) error {
+	var data bytes.Buffer
+	for _, point := range points {
+		data.WriteString(point.influxLine(w.measurement))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), perfdataForwardTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &data)
+	if err != nil {
+		return fmt.Errorf("http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.user != nil {
+		password, _ := w.user.Password()
+		req.SetBasicAuth(w.user.Username(), password)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", redactURLError(err, w.url))
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("http post: unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *perfdataInfluxHTTP) Close() error {
+	w.client.CloseIdleConnections()
+
+	return nil
+}
+
+// perfdataInfluxUDP sends batches in influxdb line protocol as udp packets
+type perfdataInfluxUDP struct {
+	address     string
+	measurement string
+	conn        net.Conn
+}
+
+func (w *perfdataInfluxUDP) Write(points []*perfdataPoint) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("udp", w.address, perfdataForwardTimeout)
+		if err != nil {
+			return fmt.Errorf("cannot connect to %s: %w", w.address, err)
+		}
+		w.conn = conn
+	}
+
+	var packet bytes.Buffer
+	for _, point := range points {
+		line := point.influxLine(w.measurement)
+		if packet.Len() > 0 && packet.Len()+len(line) > perfdataForwardUDPPacketSize {
+			if err := w.send(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		packet.WriteString(line)
+	}
+
+	return w.send(packet.Bytes())
+}
+
+func (w *perfdataInfluxUDP) send(packet []byte) error {
+	if len(packet) == 0 {
+		return nil
+	}
+	_, err := w.conn.Write(packet)
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+
+		return fmt.Errorf("cannot write to %s: %w", w.address, err)
+	}
+
+	return nil
+}
+
+func (w *perfdataInfluxUDP) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+// perfdataGraphite sends batches in graphite plaintext protocol over tcp
+type perfdataGraphite struct {
+	address string
+	prefix  string
+	conn    net.Conn
+}
+
+func (w *perfdataGraphite) Write(points []*perfdataPoint) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout("tcp", w.address, perfdataForwardTimeout)
+		if err != nil {
+			return fmt.Errorf("cannot connect to %s: %w", w.address, err)
+		}
+		w.conn = conn
+	}
+
+	var data bytes.Buffer
+	for _, point := range points {
+		data.WriteString(point.graphiteLine(w.prefix))
+	}
+
+	logDebug(w.conn.SetWriteDeadline(time.Now().Add(perfdataForwardTimeout)))
+	_, err := w.conn.Write(data.Bytes())
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+
+		return fmt.Errorf("cannot write to %s: %w", w.address, err)
+	}
+
+	return nil
+}
+
+func (w *perfdataGraphite) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
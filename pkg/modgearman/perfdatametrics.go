@@ -0,0 +1,170 @@
+package modgearman
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// perfdataSeriesExpireInterval sets how often stale perfdata series are removed
+const perfdataSeriesExpireInterval = time.Minute
+
+var (
+	// perfdataSeries contains the last update of all exported perfdata series
+	perfdataSeries           = map[string]*perfdataSeriesEntry{}
+	perfdataSeriesLock       sync.Mutex
+	perfdataSeriesLastExpire time.Time
+)
+
+type perfdataSeriesEntry struct {
+	labels     []string
+	lastUpdate time.Time
+}
+
+// perfdataMetricsKey returns the string used to match allow and deny patterns
+func perfdataMetricsKey(hostName, serviceDescription, label string) string {
+	return hostName + ";" + serviceDescription + ";" + label
+}
+
+// exportPerfdata sets the perfdata of the result as prometheus gauges
+func exportPerfdata(result *answer, received *request, config *config) {
+	if !config.perfdataMetrics || config.prometheusServer == "" {
+		return
+	}
+
+	output, _ := result.pluginOutput()
+	parsed := parsePluginOutput(output)
+	now := time.Now()
+
+	perfdataSeriesLock.Lock()
+	defer perfdataSeriesLock.Unlock()
+
+	expirePerfdataSeries(config, now)
+	for _, perf := range append(parsed.perfdata, parsed.longPerfdata...) {
+		if perf.invalid || perf.value == "U" {
+			continue
+		}
+		if !config.perfdataMetricsAllowed(perfdataMetricsKey(received.hostName, received.serviceDescription, perf.label)) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.Replace(perf.value, ",", ".", 1), 64)
+		if err != nil {
+			continue
+		}
+		value, uom := perfdataBaseUnit(value, perf.uom)
+
+		labels := []string{received.hostName, received.serviceDescription, perf.label, uom}
+		key := strings.Join(labels, "\x00")
+		entry, ok := perfdataSeries[key]
+		if !ok {
+			if len(perfdataSeries) >= config.perfdataMetricsMaxSeries {
+				perfdataMetricsDroppedCounter.Inc()
+
+				continue
+			}
+			entry = &perfdataSeriesEntry{labels: labels}
+			perfdataSeries[key] = entry
+		}
+		entry.lastUpdate = now
+		perfdataGauge.WithLabelValues(labels...).Set(value)
+	}
+}
+
+// expirePerfdataSeries removes series which have not been updated within perfdata_metrics_max_age
+func expirePerfdataSeries(config *config, now time.Time) {
+	if config.perfdataMetricsMaxAge <= 0 || now.Sub(perfdataSeriesLastExpire) < perfdataSeriesExpireInterval {
+		return
+	}
+	perfdataSeriesLastExpire = now
+
+	maxAge := time.Duration(config.perfdataMetricsMaxAge) * time.Second
+	for key, entry := range perfdataSeries {
+		if now.Sub(entry.lastUpdate) > maxAge {
+			perfdataGauge.DeleteLabelValues(entry.labels...)
+			delete(perfdataSeries, key)
+		}
+	}
+}
+
+// resetPerfdataSeries removes all exported perfdata series
+func resetPerfdataSeries() {
+	perfdataSeriesLock.Lock()
+	defer perfdataSeriesLock.Unlock()
+
+	perfdataGauge.Reset()
+	perfdataSeries = map[string]*perfdataSeriesEntry{}
+}
+
+// perfdataBaseUnit converts values into prometheus base units, seconds and bytes
+func perfdataBaseUnit(value float64, uom string) (float64, string) {
+	switch uom {
+	case "ms":
+		return value / 1e3, "s"
+	case "us":
+		return value / 1e6, "s"
+	case "KB":
+		return value * 1024, "B"
+	case "MB":
+		return value * 1024 * 1024, "B"
+	case "GB":
+		return value * 1024 * 1024 * 1024, "B"
+	case "TB":
+		return value * 1024 * 1024 * 1024 * 1024, "B"
+	}
+
+	return value, uom
+}
+
+// perfdataMetricsAllowed returns true if the key matches any allow pattern (if set) and no deny pattern
+func (config *config) perfdataMetricsAllowed(key string) bool {
+	for _, deny := range config.perfdataMetricsDenyRe {
+		if deny.MatchString(key) {
+			return false
+		}
+	}
+	if len(config.perfdataMetricsAllowRe) == 0 {
+		return true
+	}
+	for _, allow := range config.perfdataMetricsAllowRe {
+		if allow.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPerfdataMetricsConfig compiles the allow and deny patterns
+func checkPerfdataMetricsConfig(config *config) error {
+	var err error
+	config.perfdataMetricsAllowRe, err = compilePerfdataPatterns("perfdata_metrics_allow", config.perfdataMetricsAllow)
+	if err != nil {
+		return err
+	}
+	config.perfdataMetricsDenyRe, err = compilePerfdataPatterns("perfdata_metrics_deny", config.perfdataMetricsDeny)
+	if err != nil {
+		return err
+	}
+
+	if config.perfdataMetrics && config.prometheusServer == "" {
+		log.Warnf("perfdata_metrics requires prometheus_server to be set")
+	}
+
+	return nil
+}
+
+func compilePerfdataPatterns(option string, patterns []string) ([]*regexp.Regexp, error) {
+	list := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %s: %s", option, pattern, err.Error())
+		}
+		list = append(list, re)
+	}
+
+	return list, nil
+}
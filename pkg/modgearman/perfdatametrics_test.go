@@ -0,0 +1,62 @@
+package modgearman
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func perfdataGaugeValue(t *testing.T, labels ...string) float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	require.NoError(t, perfdataGauge.WithLabelValues(labels...).Write(metric))
+
+	return metric.GetGauge().GetValue()
+}
+
+func TestExportPerfdata(t *testing.T) {
+	resetPerfdataSeries()
+	defer resetPerfdataSeries()
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.prometheusServer = "127.0.0.1:9050"
+	cfg.perfdataMetrics = true
+	cfg.perfdataMetricsDeny = []string{";pl$"}
+	require.NoError(t, checkPerfdataMetricsConfig(&cfg))
+
+	received := &request{hostName: "host1", serviceDescription: "ping"}
+	exportPerfdata(&answer{output: "OK|rta=150ms;100;500 pl=0%\nlong|'disk usage'=2KB u=U"}, received, &cfg)
+
+	assert.Len(t, perfdataSeries, 2)
+	assert.InDelta(t, 0.15, perfdataGaugeValue(t, "host1", "ping", "rta", "s"), 0.0001)
+	assert.InDelta(t, 2048, perfdataGaugeValue(t, "host1", "ping", "disk usage", "B"), 0)
+
+	// allow patterns
+	cfg.perfdataMetricsAllow = []string{"^host2;"}
+	require.NoError(t, checkPerfdataMetricsConfig(&cfg))
+	exportPerfdata(&answer{output: `OK|rta=1ms`}, &request{hostName: "host3", serviceDescription: "ping"}, &cfg)
+	exportPerfdata(&answer{output: `OK|rta=1ms`}, &request{hostName: "host2", serviceDescription: "ping"}, &cfg)
+	assert.Len(t, perfdataSeries, 3)
+
+	// cardinality limit
+	cfg.perfdataMetricsAllow = nil
+	cfg.perfdataMetricsMaxSeries = 3
+	require.NoError(t, checkPerfdataMetricsConfig(&cfg))
+	exportPerfdata(&answer{output: `OK|rta=1ms`}, &request{hostName: "host4", serviceDescription: "ping"}, &cfg)
+	assert.Len(t, perfdataSeries, 3)
+
+	// expire stale series
+	perfdataSeriesLastExpire = time.Time{}
+	for _, entry := range perfdataSeries {
+		entry.lastUpdate = time.Now().Add(-2 * time.Hour)
+	}
+	exportPerfdata(&answer{output: `OK|rta=1ms`}, &request{hostName: "host4", serviceDescription: "ping"}, &cfg)
+	assert.Len(t, perfdataSeries, 1)
+
+	cfg.perfdataMetricsDeny = []string{"("}
+	require.Error(t, checkPerfdataMetricsConfig(&cfg))
+}
@@ -34,11 +34,171 @@ var (
 		Help: "Total number of busy Workers",
 	})
 
+	poolWorkerCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_pool_workers_total",
+			Help: "Total number of currently existing Workers by pool",
+		},
+		[]string{"pool"},
+	)
+
+	poolIdleWorkerCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_pool_workers_idle",
+			Help: "Total number of currently idling Workers by pool",
+		},
+		[]string{"pool"},
+	)
+
+	pressureGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_pressure_percent",
+			Help: "Pressure stall information from /proc/pressure, requires psi_limit",
+		},
+		[]string{"resource", "type", "window"},
+	)
+
+	drainingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modgearmanworker_draining",
+		Help: "1 if the worker is in drain mode and does not fetch new jobs",
+	})
+
+	scalingDecisionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_scaling_decisions_total",
+			Help: "total number of started and stopped workers and of pools becoming blocked by pool and reason",
+		},
+		[]string{"pool", "action", "reason"},
+	)
+
+	queueWaitingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_gearmand_queue_waiting",
+			Help: "Number of waiting jobs by queue summed up over all servers, requires queue_scaling",
+		},
+		[]string{"queue"},
+	)
+
+	poolWorkingWorkerCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_pool_workers_busy",
+			Help: "Total number of busy Workers by pool",
+		},
+		[]string{"pool"},
+	)
+
 	ballooningWorkerCount = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "modgearmanworker_workers_ballooning",
 		Help: "Total number of extra ballooning Workers running",
 	})
 
+	resultSpoolEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modgearmanworker_result_spool_entries",
+		Help: "Total number of undeliverable results waiting in the result spool",
+	})
+
+	resultSpoolOldestAge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modgearmanworker_result_spool_oldest_age_seconds",
+		Help: "Age of the oldest result waiting in the result spool",
+	})
+
+	dupServerQueuedCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_dupserver_results_queued",
+			Help: "Total number of results waiting to be sent to the dupserver",
+		},
+		[]string{"address"},
+	)
+
+	dupServerDroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_dupserver_results_dropped_total",
+			Help: "total number of results dropped because the dupserver backlog was full or expired",
+		},
+		[]string{"address"},
+	)
+
+	dupServerReplayedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_dupserver_results_replayed_total",
+			Help: "total number of results replayed from the persistent dupserver backlog",
+		},
+		[]string{"address"},
+	)
+
+	resultSinkErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_result_sink_errors_total",
+			Help: "total number of results which could not be delivered to a result sink",
+		},
+		[]string{"sink"},
+	)
+
+	outputTruncatedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_output_truncated_total",
+			Help: "total number of results with plugin output truncated to max_output_size",
+		},
+		[]string{"type", "exec"},
+	)
+
+	perfdataErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_perfdata_errors_total",
+			Help: "total number of malformed performance data values by command basename",
+		},
+		[]string{"command"},
+	)
+
+	perfdataGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modgearmanworker_perfdata_value",
+			Help: "performance data values of executed plugins, converted to seconds and bytes",
+		},
+		[]string{"host_name", "service_description", "label", "uom"},
+	)
+
+	perfdataMetricsDroppedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "modgearmanworker_perfdata_series_dropped_total",
+		Help: "total number of perfdata values not exported because perfdata_metrics_max_series has been reached",
+	})
+
+	perfdataForwardErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_perfdata_forward_errors_total",
+			Help: "total number of failed attempts to send perfdata to a time series backend",
+		},
+		[]string{"target"},
+	)
+
+	perfdataForwardDroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modgearmanworker_perfdata_forward_dropped_total",
+			Help: "total number of results and perfdata values dropped because the forward queue or buffer was full",
+		},
+		[]string{"target"},
+	)
+
+	smoothingWaitingCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "modgearmanworker_check_smoothing_waiting",
+		Help: "Number of checks currently delayed by check_smoothing_window",
+	})
+
+	smoothingDelayCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "modgearmanworker_check_smoothing_delay_seconds_total",
+		Help: "total seconds checks have been delayed by check_smoothing_window",
+	})
+
+	resultCacheHitCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "modgearmanworker_result_cache_hits_total",
+		Help: "total number of checks which reused the result of an identical command",
+	})
+
+	resultCacheMissCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "modgearmanworker_result_cache_misses_total",
+		Help: "total number of cacheable checks which had to be executed",
+	})
+
 	taskCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "modgearmanworker_tasks_completed_total",
@@ -100,10 +260,16 @@ func startPrometheus(config *config) (prometheusListener net.Listener) {
 			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
 		)
 		mux.Handle("/metrics", handler)
+		if config.statusServer == config.prometheusServer {
+			registerStatusHandlers(mux, config)
+		}
 		logDebug(http.Serve(listen, mux))
 		log.Debugf("prometheus listener %s stopped", config.prometheusServer)
 	}()
 	log.Debugf("serving prometheus metrics at %s/metrics", config.prometheusServer)
+	if config.statusServer == config.prometheusServer {
+		log.Debugf("serving status api at %s/status", config.prometheusServer)
+	}
 
 	return prometheusListener
 }
@@ -151,10 +317,102 @@ func registerMetrics() {
 		log.Errorf("prometheus register failed: %s", err.Error())
 	}
 
+	if err := prometheus.Register(poolWorkerCount); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(poolIdleWorkerCount); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(pressureGauge); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(drainingGauge); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(scalingDecisionCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(queueWaitingGauge); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(poolWorkingWorkerCount); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
 	if err := prometheus.Register(ballooningWorkerCount); err != nil {
 		log.Errorf("prometheus register failed: %s", err.Error())
 	}
 
+	if err := prometheus.Register(resultSpoolEntries); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(resultSpoolOldestAge); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(dupServerQueuedCount); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(dupServerDroppedCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(dupServerReplayedCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(resultSinkErrorCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(outputTruncatedCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(perfdataErrorCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(perfdataGauge); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(perfdataMetricsDroppedCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(perfdataForwardErrorCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(perfdataForwardDroppedCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(smoothingWaitingCount); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(smoothingDelayCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(resultCacheHitCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
+	if err := prometheus.Register(resultCacheMissCounter); err != nil {
+		log.Errorf("prometheus register failed: %s", err.Error())
+	}
+
 	if err := prometheus.Register(userTimes); err != nil {
 		log.Errorf("prometheus register failed: %s", err.Error())
 	}
@@ -231,3 +489,33 @@ func promCounterVecSum(counterVec *prometheus.CounterVec) (totalSum float64) {
 
 	return totalSum
 }
+
+// promCounterVecByLabel returns the counter sums grouped by the given label
+func promCounterVecByLabel(counterVec *prometheus.CounterVec, label string) map[string]float64 {
+	sums := map[string]float64{}
+	metrics := make(chan prometheus.Metric)
+
+	go func() {
+		defer logPanicExit()
+
+		counterVec.Collect(metrics)
+		close(metrics)
+	}()
+
+	for metric := range metrics {
+		metricProto := &dto.Metric{}
+		if err := metric.Write(metricProto); err != nil {
+			log.Warnf("Error writing metric: %s", err.Error())
+
+			continue
+		}
+
+		for _, pair := range metricProto.GetLabel() {
+			if pair.GetName() == label {
+				sums[pair.GetValue()] += metricProto.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return sums
+}
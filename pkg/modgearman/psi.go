@@ -0,0 +1,172 @@
+package modgearman
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// psiResources contains the resources available in /proc/pressure
+var psiResources = []string{"cpu", "memory", "io"}
+
+// psiLimit is a threshold on a pressure stall information value, ex.: cpu.some.avg10=50
+type psiLimit struct {
+	resource string // cpu, memory or io
+	typ      string // some or full
+	window   string // avg10, avg60 or avg300
+	limit    float64
+}
+
+func (l *psiLimit) key() string {
+	return l.resource + "." + l.typ + "." + l.window
+}
+
+// parsePsiLimit parses a psi_limit definition like <cpu|memory|io>.<some|full>.<avg10|avg60|avg300>=<percent>
+func parsePsiLimit(definition string) (*psiLimit, error) {
+	key, value, ok := strings.Cut(definition, "=")
+	parts := strings.Split(strings.TrimSpace(key), ".")
+	if !ok || len(parts) != 3 {
+		return nil, fmt.Errorf("invalid psi_limit %s, expected <cpu|memory|io>.<some|full>.<avg10|avg60|avg300>=<percent>", definition)
+	}
+	limit := &psiLimit{resource: parts[0], typ: parts[1], window: parts[2]}
+
+	switch limit.resource {
+	case "cpu", "memory", "io":
+	default:
+		return nil, fmt.Errorf("invalid psi_limit %s, resource must be one of: cpu, memory, io", definition)
+	}
+	switch limit.typ {
+	case "some", "full":
+	default:
+		return nil, fmt.Errorf("invalid psi_limit %s, type must be one of: some, full", definition)
+	}
+	switch limit.window {
+	case "avg10", "avg60", "avg300":
+	default:
+		return nil, fmt.Errorf("invalid psi_limit %s, window must be one of: avg10, avg60, avg300", definition)
+	}
+
+	var err error
+	limit.limit, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || limit.limit <= 0 || limit.limit > 100 {
+		return nil, fmt.Errorf("invalid psi_limit %s, limit must be a percentage between 0 and 100", definition)
+	}
+
+	return limit, nil
+}
+
+// parsePressure parses the content of a /proc/pressure file into values by <resource>.<type>.<window>
+func parsePressure(resource, content string, values map[string]float64) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		typ := fields[0]
+		for _, field := range fields[1:] {
+			window, value, ok := strings.Cut(field, "=")
+			if !ok || !strings.HasPrefix(window, "avg") {
+				continue
+			}
+			num, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			values[resource+"."+typ+"."+window] = num
+		}
+	}
+}
+
+// updatePressure reads the current pressure stall information from /proc/pressure
+func (w *mainWorker) updatePressure() {
+	if len(w.cfg.psiLimitRules) == 0 {
+		return
+	}
+
+	values := map[string]float64{}
+	for _, resource := range psiResources {
+		content, err := os.ReadFile("/proc/pressure/" + resource)
+		if err != nil {
+			continue
+		}
+		parsePressure(resource, string(content), values)
+	}
+	for key, value := range values {
+		parts := strings.Split(key, ".")
+		pressureGauge.WithLabelValues(parts[0], parts[1], parts[2]).Set(value)
+	}
+
+	w.pressureLock.Lock()
+	w.pressure = values
+	w.pressureLock.Unlock()
+}
+
+// checkPressure checks all psi limits against the last pressure values
+func (w *mainWorker) checkPressure() (ok bool, reason string) {
+	if len(w.cfg.psiLimitRules) == 0 {
+		return true, ""
+	}
+
+	w.pressureLock.RLock()
+	defer w.pressureLock.RUnlock()
+
+	for _, limit := range w.cfg.psiLimitRules {
+		value, exists := w.pressure[limit.key()]
+		if !exists || value <= limit.limit {
+			continue
+		}
+		reason = fmt.Sprintf("cannot start any more worker, %s pressure is too high: %.2f > %.2f", limit.key(), value, limit.limit)
+		log.Debug(reason)
+
+		return false, reason
+	}
+
+	return true, ""
+}
+
+// pressureStatus returns the pressure values of all configured limits for the stats log line
+func (w *mainWorker) pressureStatus() string {
+	if len(w.cfg.psiLimitRules) == 0 {
+		return ""
+	}
+
+	w.pressureLock.RLock()
+	defer w.pressureLock.RUnlock()
+
+	status := make([]string, 0, len(w.cfg.psiLimitRules))
+	for _, limit := range w.cfg.psiLimitRules {
+		value, ok := w.pressure[limit.key()]
+		if !ok {
+			status = append(status, limit.key()+"=n/a")
+
+			continue
+		}
+		status = append(status, fmt.Sprintf("%s=%.2f", limit.key(), value))
+	}
+
+	return strings.Join(status, " ")
+}
+
+// checkPsiConfig parses all psi_limit definitions
+func checkPsiConfig(config *config) error {
+	config.psiLimitRules = nil
+	for _, definition := range config.psiLimits {
+		limit, err := parsePsiLimit(definition)
+		if err != nil {
+			return err
+		}
+		config.psiLimitRules = append(config.psiLimitRules, limit)
+	}
+
+	if len(config.psiLimitRules) > 0 {
+		if runtime.GOOS != "linux" {
+			log.Warnf("psi_limit is only supported on linux")
+		} else if _, err := os.Stat("/proc/pressure"); err != nil {
+			log.Warnf("psi_limit requires a kernel with pressure stall information (/proc/pressure): %s", err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePsiLimit(t *testing.T) {
+	limit, err := parsePsiLimit("cpu.some.avg10=50")
+	require.NoError(t, err)
+	assert.Equal(t, &psiLimit{resource: "cpu", typ: "some", window: "avg10", limit: 50}, limit)
+	assert.Equal(t, "cpu.some.avg10", limit.key())
+
+	limit, err = parsePsiLimit("io.full.avg300 = 12.5")
+	require.NoError(t, err)
+	assert.InDelta(t, 12.5, limit.limit, 0.001)
+
+	for _, definition := range []string{"", "cpu=50", "cpu.some=50", "disk.some.avg10=50", "cpu.most.avg10=50",
+		"cpu.some.avg5=50", "cpu.some.avg10", "cpu.some.avg10=0", "cpu.some.avg10=101", "cpu.some.avg10=abc"} {
+		_, err = parsePsiLimit(definition)
+		require.Errorf(t, err, "definition: %s", definition)
+	}
+}
+
+func TestParsePressure(t *testing.T) {
+	values := map[string]float64{}
+	parsePressure("memory", "some avg10=1.50 avg60=0.25 avg300=0.00 total=12345\nfull avg10=0.75 avg60=0.00 avg300=0.00 total=456\n", values)
+	assert.Equal(t, map[string]float64{
+		"memory.some.avg10":  1.5,
+		"memory.some.avg60":  0.25,
+		"memory.some.avg300": 0,
+		"memory.full.avg10":  0.75,
+		"memory.full.avg60":  0,
+		"memory.full.avg300": 0,
+	}, values)
+}
+
+func TestCheckPressure(t *testing.T) {
+	cfg := config{psiLimits: []string{"cpu.some.avg10=50", "io.full.avg60=10"}}
+	require.NoError(t, checkPsiConfig(&cfg))
+	mainworker := &mainWorker{cfg: &cfg}
+
+	// no values available
+	ok, _ := mainworker.checkPressure()
+	assert.True(t, ok)
+	assert.Equal(t, "cpu.some.avg10=n/a io.full.avg60=n/a", mainworker.pressureStatus())
+
+	mainworker.pressure = map[string]float64{"cpu.some.avg10": 20, "io.full.avg60": 5}
+	ok, _ = mainworker.checkPressure()
+	assert.True(t, ok)
+	assert.Equal(t, "cpu.some.avg10=20.00 io.full.avg60=5.00", mainworker.pressureStatus())
+
+	mainworker.pressure["io.full.avg60"] = 15
+	ok, reason := mainworker.checkPressure()
+	assert.False(t, ok)
+	assert.Contains(t, reason, "io.full.avg60 pressure is too high")
+
+	cfg.psiLimits = []string{"cpu.some.avg10"}
+	require.Error(t, checkPsiConfig(&cfg))
+}
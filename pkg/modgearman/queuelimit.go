@@ -0,0 +1,66 @@
+package modgearman
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// queueLimit reserves dedicated worker for a queue and optionally caps the number of concurrent jobs
+type queueLimit struct {
+	queue     string
+	minWorker int // number of worker reserved for this queue
+	maxWorker int // maximum number of concurrent jobs from this queue, 0 means unlimited
+}
+
+// parseQueueLimit parses a queue_limit definition like <queue>:<min>[:<max>]
+func parseQueueLimit(definition string) (*queueLimit, error) {
+	parts := strings.Split(definition, ":")
+	if len(parts) < 2 || len(parts) > 3 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("invalid queue_limit %s, expected <queue>:<min>[:<max>]", definition)
+	}
+
+	limit := &queueLimit{queue: strings.TrimSpace(parts[0])}
+	for i, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 {
+			return nil, fmt.Errorf("invalid queue_limit %s, worker numbers must be positive integers", definition)
+		}
+		if i == 0 {
+			limit.minWorker = num
+		} else {
+			limit.maxWorker = num
+		}
+	}
+
+	if limit.maxWorker > 0 {
+		if limit.minWorker > limit.maxWorker {
+			return nil, fmt.Errorf("invalid queue_limit %s, min must not exceed max", definition)
+		}
+		// capped queues are not served by the shared worker, so keep at least one worker
+		limit.minWorker = max(limit.minWorker, 1)
+	}
+
+	return limit, nil
+}
+
+// pool returns a worker pool with the dedicated worker for this queue. Queues without
+// a maximum keep exactly the reserved worker, the shared worker serve them as well.
+func (limit *queueLimit) pool(config *config) *workerPool {
+	pool := newWorkerPool("queue:"+limit.queue, config)
+	pool.queues = []string{limit.queue}
+	pool.minWorker = limit.minWorker
+	pool.maxWorker = limit.minWorker
+	if limit.maxWorker > 0 {
+		pool.maxWorker = limit.maxWorker
+		// backgrounded and concurrent jobs would exceed the queue limit
+		pool.noBallooning = true
+		pool.jobsPerConnection = 1
+	}
+
+	return pool
+}
@@ -0,0 +1,93 @@
+package modgearman
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueueLimit(t *testing.T) {
+	limit, err := parseQueueLimit("notification:2")
+	require.NoError(t, err)
+	assert.Equal(t, &queueLimit{queue: "notification", minWorker: 2}, limit)
+
+	limit, err = parseQueueLimit("hostgroup_slow:0:10")
+	require.NoError(t, err)
+	assert.Equal(t, &queueLimit{queue: "hostgroup_slow", minWorker: 1, maxWorker: 10}, limit)
+
+	limit, err = parseQueueLimit("service::5")
+	require.NoError(t, err)
+	assert.Equal(t, &queueLimit{queue: "service", minWorker: 1, maxWorker: 5}, limit)
+
+	for _, definition := range []string{"notification", ":2", "host:a", "host:-1", "host:5:2", "host:1:2:3"} {
+		_, err := parseQueueLimit(definition)
+		require.Errorf(t, err, "parsing %s", definition)
+	}
+}
+
+func TestQueueLimitQueues(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.hosts = true
+	cfg.services = true
+	cfg.notifications = true
+	cfg.hostgroups = []string{"slow"}
+	cfg.queueLimits = []string{"notification:2", "hostgroup_slow:0:10"}
+
+	require.NoError(t, checkWorkerPools(&cfg))
+	assert.Equal(t, []string{"host", "service", "notification", "hostgroup_slow"}, cfg.checkQueues())
+	assert.Equal(t, []string{"host", "service", "notification"}, cfg.sharedQueues())
+
+	pools := cfg.workerPools()
+	require.Len(t, pools, 3)
+	assert.Equal(t, "default", pools[0].name)
+	assert.Equal(t, "queue:notification", pools[1].name)
+	assert.Equal(t, 2, pools[1].minWorker)
+	assert.Equal(t, 2, pools[1].maxWorker)
+	assert.False(t, pools[1].noBallooning)
+	assert.Equal(t, "queue:hostgroup_slow", pools[2].name)
+	assert.Equal(t, 10, pools[2].maxWorker)
+	assert.True(t, pools[2].noBallooning)
+
+	shared := &worker{config: &cfg}
+	assert.Equal(t, []string{"host", "service", "notification"}, shared.listenQueues())
+	dedicated := &worker{config: &cfg, pool: pools[2]}
+	assert.Equal(t, []string{"hostgroup_slow"}, dedicated.listenQueues())
+
+	cfg.queueLimits = []string{"eventhandler:1"}
+	require.Error(t, checkWorkerPools(&cfg))
+
+	cfg.queueLimits = []string{"host:1", "host:0:2"}
+	require.Error(t, checkWorkerPools(&cfg))
+}
+
+func TestQueueLimitCountWorker(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.backgroundingThreshold = 30
+	pool := (&queueLimit{queue: "hostgroup_slow", minWorker: 1, maxWorker: 10}).pool(&cfg)
+
+	mainworker := &mainWorker{
+		cfg:           &cfg,
+		workerMap:     make(map[string]*worker),
+		workerMapLock: new(sync.RWMutex),
+	}
+	mainworker.workerUtilization.Store(100)
+	mainworker.workerMap["w1"] = &worker{id: "w1", activeJobs: 1, config: &cfg, mainWorker: mainworker}
+	mainworker.workerMap["w2"] = &worker{id: "w2", config: &cfg, mainWorker: mainworker}
+	mainworker.workerMap["w3"] = &worker{id: "w3", activeJobs: 1, config: &cfg, mainWorker: mainworker, pool: pool}
+
+	total, active := mainworker.countWorker("default")
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, active)
+
+	total, active = mainworker.countWorker("queue:hostgroup_slow")
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 1, active)
+
+	// capped queue worker must not balloon
+	assert.True(t, mainworker.workerMap["w1"].considerballooning())
+	assert.False(t, mainworker.workerMap["w3"].considerballooning())
+}
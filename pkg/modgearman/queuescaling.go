@@ -0,0 +1,176 @@
+package modgearman
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// scaling actions and reasons exposed in the scaling decisions metric
+const (
+	scalingActionStart   = "start"
+	scalingActionStop    = "stop"
+	scalingActionBlocked = "blocked"
+
+	scalingReasonMinWorker    = "min_worker"
+	scalingReasonUtilization  = "utilization"
+	scalingReasonQueueWaiting = "queue_waiting"
+	scalingReasonIdleTimeout  = "idle_timeout"
+	scalingReasonQueueEmpty   = "queue_empty"
+	scalingReasonMaxWorker    = "max_worker"
+	scalingReasonLoadLimit    = "load_limit"
+	scalingReasonMemLimit     = "mem_limit"
+	scalingReasonPsiLimit     = "psi_limit"
+)
+
+// queueStatsMaxAgeFactor sets after how many missed intervals queue statistics are considered stale
+const queueStatsMaxAgeFactor = 3
+
+// queueStats contains the waiting jobs of all queues summed up over all gearmand servers
+type queueStats struct {
+	lock        sync.RWMutex
+	connections map[string]net.Conn
+	waiting     map[string]int
+	emptySince  map[string]time.Time
+	updated     time.Time
+}
+
+func newQueueStats() *queueStats {
+	return &queueStats{
+		connections: make(map[string]net.Conn),
+		waiting:     make(map[string]int),
+		emptySince:  make(map[string]time.Time),
+	}
+}
+
+// update queries the admin status of all servers and stores the waiting jobs
+// of the given queues, all other queues of the servers are ignored
+func (stats *queueStats) update(servers, queues []string, now time.Time) {
+	registered := map[string]bool{}
+	for _, name := range queues {
+		registered[name] = true
+	}
+	waiting := map[string]int{}
+	success := false
+	for _, address := range servers {
+		status, _, err := processGearmanQueues(address, stats.connections)
+		if err != nil {
+			log.Debugf("queue_scaling: failed to query status from %s: %s", address, err.Error())
+			stats.closeConnection(address)
+
+			continue
+		}
+		success = true
+		for _, queue := range status {
+			if registered[queue.Name] {
+				waiting[queue.Name] += queue.Waiting
+			}
+		}
+	}
+	if !success {
+		return
+	}
+
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	for name, num := range waiting {
+		queueWaitingGauge.WithLabelValues(name).Set(float64(num))
+		if num > 0 {
+			delete(stats.emptySince, name)
+		} else if _, ok := stats.emptySince[name]; !ok {
+			stats.emptySince[name] = now
+		}
+	}
+	for name := range stats.waiting {
+		if _, ok := waiting[name]; !ok {
+			queueWaitingGauge.DeleteLabelValues(name)
+			delete(stats.emptySince, name)
+		}
+	}
+	stats.waiting = waiting
+	stats.updated = now
+}
+
+func (stats *queueStats) closeConnection(address string) {
+	if conn, ok := stats.connections[address]; ok {
+		conn.Close()
+		delete(stats.connections, address)
+	}
+}
+
+// close closes all admin connections
+func (stats *queueStats) close() {
+	for address := range stats.connections {
+		stats.closeConnection(address)
+	}
+}
+
+// poolWaiting returns the number of waiting jobs in all queues of the pool
+// and the time since when all those queues have been empty. ok is false if
+// there are no recent statistics.
+func (stats *queueStats) poolWaiting(pool *workerPool, maxAge time.Duration, now time.Time) (waiting int, emptySince time.Time, ok bool) {
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+
+	if stats.updated.IsZero() || now.Sub(stats.updated) > maxAge {
+		return 0, time.Time{}, false
+	}
+
+	// queues unknown to gearmand are ignored
+	for _, name := range pool.queues {
+		waiting += stats.waiting[name]
+		if since, empty := stats.emptySince[name]; empty && since.After(emptySince) {
+			emptySince = since
+		}
+	}
+	if waiting > 0 {
+		emptySince = time.Time{}
+	}
+
+	return waiting, emptySince, true
+}
+
+// runQueueScaling regularly updates the queue statistics as long as the main worker is running
+func (w *mainWorker) runQueueScaling() {
+	defer logPanicExit()
+	defer w.queueStats.close()
+
+	interval := time.Duration(w.cfg.queueScalingInterval) * time.Second
+	for w.running {
+		w.queueStats.update(w.ActiveServerList(), w.cfg.checkQueues(), time.Now())
+		time.Sleep(interval)
+	}
+}
+
+// queueWaiting returns the queue statistics of the pool if queue_scaling is enabled
+func (w *mainWorker) queueWaiting(pool *workerPool) (waiting int, emptySince time.Time, ok bool) {
+	if !w.cfg.queueScaling || w.queueStats == nil {
+		return 0, time.Time{}, false
+	}
+	maxAge := time.Duration(w.cfg.queueScalingInterval*queueStatsMaxAgeFactor) * time.Second
+
+	return w.queueStats.poolWaiting(pool, maxAge, time.Now())
+}
+
+func countScalingDecision(pool *workerPool, action, reason string, num int) {
+	scalingDecisionCounter.WithLabelValues(pool.name, action, reason).Add(float64(num))
+}
+
+// setScalingBlocked stores why the pool cannot start more worker, empty if not blocked.
+// Blocked decisions are counted once when the pool becomes blocked or the reason changes.
+func (w *mainWorker) setScalingBlocked(pool *workerPool, reason string) {
+	if w.scalingBlocked == nil {
+		w.scalingBlocked = make(map[string]string)
+	}
+	if w.scalingBlocked[pool.name] == reason {
+		return
+	}
+	if reason == "" {
+		delete(w.scalingBlocked, pool.name)
+
+		return
+	}
+	w.scalingBlocked[pool.name] = reason
+	countScalingDecision(pool, scalingActionBlocked, reason, 1)
+}
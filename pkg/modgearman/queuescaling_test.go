@@ -0,0 +1,121 @@
+package modgearman
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeGearmandAdmin answers admin status requests with the given status lines
+func startFakeGearmandAdmin(t *testing.T, status string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == "version\n" {
+						_, _ = conn.Write([]byte(status + ".\nOK 1.1.21\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestQueueStatsUpdate(t *testing.T) {
+	addr1 := startFakeGearmandAdmin(t, "service\t10\t4\t5\nhost\t0\t0\t5\nother_worker\t7\t0\t1\n")
+	addr2 := startFakeGearmandAdmin(t, "service\t3\t1\t2\nhostgroup_slow\t0\t0\t1\n")
+
+	stats := newQueueStats()
+	defer stats.close()
+	queues := []string{"host", "service", "hostgroup_slow"}
+	now := time.Now()
+	stats.update([]string{addr1, addr2, "127.0.0.1:1"}, queues, now)
+
+	// queues of other worker are ignored
+	assert.Equal(t, map[string]int{"service": 8, "host": 0, "hostgroup_slow": 0}, stats.waiting)
+
+	pool := &workerPool{name: defaultWorkerPool, queues: []string{"host", "service"}}
+	waiting, emptySince, ok := stats.poolWaiting(pool, time.Minute, now)
+	assert.True(t, ok)
+	assert.Equal(t, 8, waiting)
+	assert.True(t, emptySince.IsZero())
+
+	slow := &workerPool{name: "slow", queues: []string{"hostgroup_slow", "hostgroup_unknown"}}
+	waiting, emptySince, ok = stats.poolWaiting(slow, time.Minute, now)
+	assert.True(t, ok)
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, now, emptySince)
+
+	// empty since is kept across updates
+	later := now.Add(10 * time.Second)
+	stats.update([]string{addr2}, queues, later)
+	_, emptySince, _ = stats.poolWaiting(slow, time.Minute, later)
+	assert.Equal(t, now, emptySince)
+
+	// stale statistics are not used
+	_, _, ok = stats.poolWaiting(pool, time.Minute, later.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	// failed updates keep the previous statistics
+	stats.update([]string{"127.0.0.1:1"}, queues, later.Add(time.Second))
+	assert.Equal(t, later, stats.updated)
+}
+
+func TestQueueWaitingDisabled(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	mainworker := &mainWorker{cfg: &cfg, queueStats: newQueueStats()}
+	mainworker.queueStats.update([]string{startFakeGearmandAdmin(t, "service\t10\t0\t1\n")}, []string{"service"}, time.Now())
+
+	pool := &workerPool{name: defaultWorkerPool, queues: []string{"service"}}
+	_, _, ok := mainworker.queueWaiting(pool)
+	assert.False(t, ok)
+
+	cfg.queueScaling = true
+	waiting, _, ok := mainworker.queueWaiting(pool)
+	assert.True(t, ok)
+	assert.Equal(t, 10, waiting)
+}
+
+func TestScalingBlockedTransition(t *testing.T) {
+	mainworker := &mainWorker{}
+	pool := &workerPool{name: "blocked_test"}
+	counter := scalingDecisionCounter.WithLabelValues(pool.name, scalingActionBlocked, scalingReasonMaxWorker)
+
+	// staying blocked is counted once
+	mainworker.setScalingBlocked(pool, scalingReasonMaxWorker)
+	mainworker.setScalingBlocked(pool, scalingReasonMaxWorker)
+	assert.InDelta(t, 1, testutil.ToFloat64(counter), 0)
+
+	// blocked again after being unblocked
+	mainworker.setScalingBlocked(pool, "")
+	mainworker.setScalingBlocked(pool, scalingReasonMaxWorker)
+	assert.InDelta(t, 2, testutil.ToFloat64(counter), 0)
+
+	// a different reason is counted as well
+	mainworker.setScalingBlocked(pool, scalingReasonLoadLimit)
+	assert.InDelta(t, 1, testutil.ToFloat64(scalingDecisionCounter.WithLabelValues(pool.name, scalingActionBlocked, scalingReasonLoadLimit)), 0)
+	assert.InDelta(t, 2, testutil.ToFloat64(counter), 0)
+}
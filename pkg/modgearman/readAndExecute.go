@@ -12,6 +12,8 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/kdar/factorlog"
 )
 
 const (
@@ -28,6 +30,7 @@ type answer struct {
 	returnCode         int
 	source             string
 	output             string
+	rawOutput          string // output of execCmd before newlines have been escaped
 	resultQueue        string
 	active             string
 	execType           string
@@ -35,6 +38,7 @@ type answer struct {
 	runSysDuration     float64
 	compileDuration    float64
 	timedOut           bool
+	traceCtx           context.Context // context of the job span which created this result
 }
 
 func (a *answer) String() string {
@@ -68,8 +72,59 @@ output=%s
 	return result
 }
 
+// answerJSON is the json representation of an answer used for spool files and result sinks
+type answerJSON struct {
+	HostName           string  `json:"host_name"`
+	ServiceDescription string  `json:"service_description,omitempty"`
+	CoreStartTime      float64 `json:"core_start_time"`
+	StartTime          float64 `json:"start_time"`
+	FinishTime         float64 `json:"finish_time"`
+	ReturnCode         int     `json:"return_code"`
+	Source             string  `json:"source"`
+	Output             string  `json:"output"`
+	ResultQueue        string  `json:"result_queue"`
+	Active             string  `json:"active"`
+	ExecType           string  `json:"exec_type"`
+}
+
+func newAnswerJSON(a *answer) *answerJSON {
+	return &answerJSON{
+		HostName:           a.hostName,
+		ServiceDescription: a.serviceDescription,
+		CoreStartTime:      a.coreStartTime,
+		StartTime:          a.startTime,
+		FinishTime:         a.finishTime,
+		ReturnCode:         a.returnCode,
+		Source:             a.source,
+		Output:             a.output,
+		ResultQueue:        a.resultQueue,
+		Active:             a.active,
+		ExecType:           a.execType,
+	}
+}
+
+func (j *answerJSON) answer() *answer {
+	return &answer{
+		hostName:           j.HostName,
+		serviceDescription: j.ServiceDescription,
+		coreStartTime:      j.CoreStartTime,
+		startTime:          j.StartTime,
+		finishTime:         j.FinishTime,
+		returnCode:         j.ReturnCode,
+		source:             j.Source,
+		output:             j.Output,
+		resultQueue:        j.ResultQueue,
+		active:             j.Active,
+		execType:           j.ExecType,
+	}
+}
+
 func readAndExecute(received *request, config *config) *answer {
 	var result answer
+	span, endSpan := startRequestSpan(received, "readAndExecute")
+	defer endSpan()
+	defer setSpanResult(span, &result)
+
 	// first set the start time
 	result.startTime = float64(time.Now().UnixNano()) / float64(time.Second)
 	result.source = "Mod-Gearman Worker @ " + config.identifier
@@ -94,6 +149,19 @@ func readAndExecute(received *request, config *config) *answer {
 		}
 	}
 
+	// spread checks arriving in bursts
+	if config.checkSmoothingWindow > 0 {
+		if !waitForSmoothing(received, config) {
+			if received.isAdminCanceled() {
+				setCancelResult(&result, config, received)
+			}
+			result.finishTime = float64(time.Now().UnixNano()) / float64(time.Second)
+
+			return &result
+		}
+		result.startTime = float64(time.Now().UnixNano()) / float64(time.Second)
+	}
+
 	if received.timeout <= 0 {
 		received.timeout = config.jobTimeout
 	}
@@ -101,8 +169,14 @@ func readAndExecute(received *request, config *config) *answer {
 		received.timeout = 60
 	}
 
-	// run the command
-	executeCommandLine(&result, received, config)
+	// run the command, identical commands may share the result if result_cache is enabled
+	executeCachedCommandLine(&result, received, config)
+
+	// validate and optionally fix the performance data
+	checkPerfdata(&result, received, config)
+
+	// export performance data as prometheus metrics
+	exportPerfdata(&result, received, config)
 
 	// if this is a host call, no service_description is needed, else set the description
 	// so the server recognizes the answer
@@ -137,6 +211,9 @@ func checkRestrictPath(cmdString string, restrictPath []string) bool {
 func executeCommandLine(result *answer, received *request, config *config) {
 	result.returnCode = 3
 	command := parseCommand(received.commandLine, config)
+	span, endSpan := startRequestSpan(received, "executeCommandLine", traceAttrCommand.String(getCommandQualifier(command)))
+	defer endSpan()
+	defer setSpanResult(span, result)
 	defer updatePrometheusExecMetrics(config, result, received, command)
 
 	if !checkRestrictPath(received.commandLine, config.restrictPath) {
@@ -152,6 +229,11 @@ func executeCommandLine(result *answer, received *request, config *config) {
 	}
 
 	defer func() {
+		if received.isAdminCanceled() {
+			setCancelResult(result, config, received)
+
+			return
+		}
 		if result.timedOut {
 			setTimeoutResult(result, config, received, command.Negate)
 
@@ -162,11 +244,17 @@ func executeCommandLine(result *answer, received *request, config *config) {
 		}
 	}()
 
+	// embedded perl runs as worker user outside any sandbox, so plugins with a run_as rule or sandbox are executed directly
+	if command.ExecType == EPN && (config.runAsRule(received) != nil || command.Sandbox != nil) {
+		command.ExecType = Exec
+	}
+
 	switch command.ExecType {
 	case EPN:
 		result.execType = "epn"
 		taskCounter.WithLabelValues(received.typ, result.execType).Inc()
 		execEPN(result, command, received)
+		limitOutput(result, config, received)
 	case Shell:
 		result.execType = "shell"
 		taskCounter.WithLabelValues(received.typ, result.execType).Inc()
@@ -181,22 +269,26 @@ func executeCommandLine(result *answer, received *request, config *config) {
 		result.execType = "internal"
 		taskCounter.WithLabelValues(received.typ, result.execType).Inc()
 		execInternal(result, command, received)
+		limitOutput(result, config, received)
 	default:
 		log.Panicf("unknown exec path: %v", command.ExecType)
 	}
 }
 
 func execCmd(command *command, received *request, result *answer, config *config) {
+	_, endSpan := startExecSpan(received, command, "execCmd")
+	defer endSpan()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(received.timeout)*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, command.Command, command.Args...)
 
-	// byte buffer for output
-	var errBuf bytes.Buffer
-	var outBuf bytes.Buffer
-	cmd.Stdout = &outBuf
-	cmd.Stderr = &errBuf
+	// byte buffer for output, limited to prevent huge plugin output from blowing up the worker
+	outBuf := newLimitedBuffer(config.maxOutputSize)
+	errBuf := newLimitedBuffer(config.maxOutputSize)
+	cmd.Stdout = outBuf
+	cmd.Stderr = errBuf
 	cmd.Env = os.Environ()
 	for key, val := range command.Env {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, val))
@@ -205,6 +297,28 @@ func execCmd(command *command, received *request, result *answer, config *config
 	// prevent child from receiving signals meant for the worker only
 	setSysProcAttr(cmd)
 
+	// run plugin as different user if configured
+	rule := config.runAsRule(received)
+	if rule != nil {
+		log.Tracef("running %s as user %s (uid: %d, gid: %d)", command.Command, rule.user, rule.uid, rule.gid)
+		setSysProcCredential(cmd, rule)
+	}
+
+	// apply resource limits before the plugin gets executed
+	limits := config.pluginRlimits(received.commandLine)
+	applyRlimits(cmd, limits)
+
+	// run plugin in namespace sandbox if configured
+	if command.Sandbox != nil {
+		log.Tracef("running %s in sandbox %s", command.Command, command.Sandbox.prefix)
+		command.Sandbox.apply(cmd, rule)
+	}
+
+	// isolate plugin resources if enabled
+	cgroup := newPluginCgroup(config, received.pool)
+	defer cgroup.close()
+	cgroup.apply(cmd)
+
 	err := cmd.Start()
 	if err != nil && cmd.ProcessState == nil {
 		setProcessErrorResult(result, config, err)
@@ -212,12 +326,11 @@ func execCmd(command *command, received *request, result *answer, config *config
 		return
 	}
 
-	received.Cancel = func() {
-		received.Canceled = true
+	received.setCancel(func() {
 		if cmd != nil && cmd.Process != nil {
 			logDebug(cmd.Process.Kill())
 		}
-	}
+	})
 
 	// https://github.com/golang/go/issues/18874
 	// timeout does not work for child processes and/or if file handles are still open
@@ -246,7 +359,7 @@ func execCmd(command *command, received *request, result *answer, config *config
 		return
 	}
 
-	received.Cancel = nil
+	received.setCancel(nil)
 
 	state := cmd.ProcessState
 
@@ -273,15 +386,29 @@ func execCmd(command *command, received *request, result *answer, config *config
 			result.output += "\n[" + err + "]"
 		}
 	}
+	limitOutput(result, config, received)
 
-	fixReturnCodes(result, config, state)
-	result.output = strings.Replace(strings.Trim(result.output, "\r\n"), "\n", `\n`, len(result.output))
+	switch {
+	case result.returnCode != 0 && cgroup.oomKilled():
+		setOOMKilledResult(result, config, received)
+	case result.returnCode != 0 && cgroup.poolOOMEvent():
+		fixReturnCodes(result, config, state, limits)
+		setPoolOOMEventResult(result, config, received)
+	default:
+		fixReturnCodes(result, config, state, limits)
+	}
+	result.rawOutput = strings.Trim(result.output, "\r\n")
+	result.output = strings.Replace(result.rawOutput, "\n", `\n`, len(result.rawOutput))
 }
 
 func execEPN(result *answer, cmd *command, received *request) {
 	log.Tracef("using embedded perl for: %s", cmd.Command)
+	span, endSpan := startExecSpan(received, cmd, "execEPN")
+	defer endSpan()
+
 	err := executeWithEmbeddedPerl(cmd, result, received)
 	if err != nil {
+		setSpanError(span, err)
 		if isRunning() {
 			log.Warnf("embedded perl failed for: %s: %w", cmd.Command, err)
 		} else {
@@ -290,7 +417,13 @@ func execEPN(result *answer, cmd *command, received *request) {
 	}
 }
 
-func fixReturnCodes(result *answer, config *config, state *os.ProcessState) {
+func fixReturnCodes(result *answer, config *config, state *os.ProcessState, limits map[string]uint64) {
+	if exceeded := exceededRlimit(result, state, limits); exceeded != "" {
+		result.output = fmt.Sprintf("UNKNOWN: %s. (worker: %s)", exceeded, config.identifier) + "\n" + result.output
+		result.returnCode = 3
+
+		return
+	}
 	if result.returnCode >= 0 && result.returnCode <= 3 {
 		if config.workerNameInResult != "off" && config.workerNameInResult != "" {
 			switch config.workerNameInResult {
@@ -341,13 +474,19 @@ func setTimeoutResult(result *answer, config *config, received *request, negate
 	result.timedOut = true
 	result.returnCode = config.timeoutReturn
 	originalOutput := result.output
+	fields := logFields{
+		"type":      received.typ,
+		"exec_type": result.execType,
+		"host_name": received.hostName,
+	}
 	switch received.typ {
 	case "service":
-		log.Infof("service check: %s - %s run into timeout after %d seconds",
+		fields["service_description"] = received.serviceDescription
+		logWithFields(factorlog.INFO, fields, "service check: %s - %s run into timeout after %d seconds",
 			received.hostName, received.serviceDescription, received.timeout)
 		result.output = fmt.Sprintf("(Service Check Timed Out On Worker: %s)", config.identifier)
 	case "host":
-		log.Infof("host check: %s run into timeout after %d seconds", received.hostName, received.timeout)
+		logWithFields(factorlog.INFO, fields, "host check: %s run into timeout after %d seconds", received.hostName, received.timeout)
 		result.output = fmt.Sprintf("(Host Check Timed Out On Worker: %s)", config.identifier)
 	default:
 		// eventhandler and notifications should not run into timeouts usually
@@ -366,6 +505,58 @@ func setTimeoutResult(result *answer, config *config, received *request, negate
 	}
 }
 
+// setCancelResult sets the result of jobs canceled by the admin api, these results will be sent back
+func setCancelResult(result *answer, config *config, received *request) {
+	received.setCanceled(false)
+	result.returnCode = config.cancelReturn
+	fields := logFields{
+		"type":      received.typ,
+		"exec_type": result.execType,
+		"host_name": received.hostName,
+	}
+	if received.serviceDescription != "" {
+		fields["service_description"] = received.serviceDescription
+		logWithFields(factorlog.INFO, fields, "service check: %s - %s has been canceled", received.hostName, received.serviceDescription)
+	} else {
+		logWithFields(factorlog.INFO, fields, "%s: %s has been canceled", received.typ, received.hostName)
+	}
+	result.output = fmt.Sprintf("(Check Canceled On Worker: %s)", config.identifier)
+}
+
+// setOOMKilledResult sets the result of plugins killed by the OOM killer because of the cgroup memory limit
+func setOOMKilledResult(result *answer, config *config, received *request) {
+	result.returnCode = 3
+	fields := logFields{
+		"type":      received.typ,
+		"exec_type": result.execType,
+		"host_name": received.hostName,
+	}
+	if received.serviceDescription != "" {
+		fields["service_description"] = received.serviceDescription
+	}
+	logWithFields(factorlog.WARN, fields, "%s: %s has been killed by the OOM killer (cgroup memory.max: %s)",
+		received.typ, received.commandLine, config.cgroupMemoryMax)
+	result.output = fmt.Sprintf("UNKNOWN: Plugin has been killed by the OOM killer, cgroup memory limit of %s exceeded. (worker: %s)",
+		config.cgroupMemoryMax, config.identifier) + "\n" + result.output
+}
+
+// setPoolOOMEventResult adds a note to failed plugins if the OOM killer has been active in their pool cgroup,
+// it is unknown whether this plugin or another plugin of the pool has been killed
+func setPoolOOMEventResult(result *answer, config *config, received *request) {
+	fields := logFields{
+		"type":      received.typ,
+		"exec_type": result.execType,
+		"host_name": received.hostName,
+	}
+	if received.serviceDescription != "" {
+		fields["service_description"] = received.serviceDescription
+	}
+	logWithFields(factorlog.WARN, fields, "%s: %s failed during a pool OOM event in pool %s (cgroup memory.max: %s)",
+		received.typ, received.commandLine, received.pool, config.cgroupMemoryMax)
+	result.output += fmt.Sprintf("\n(pool OOM event: the OOM killer has been active in worker pool %s, cgroup memory limit of %s reached. (worker: %s))",
+		received.pool, config.cgroupMemoryMax, config.identifier)
+}
+
 func setProcessErrorResult(result *answer, config *config, err error) {
 	if os.IsNotExist(err) {
 		result.output = fmt.Sprintf("UNKNOWN: Return code of 127 is out of bounds. Make sure the plugin you're trying to run actually exists. (worker: %s)",
@@ -0,0 +1,220 @@
+package modgearman
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultCacheExpireInterval sets how often expired cache entries are removed
+const resultCacheExpireInterval = time.Minute
+
+var (
+	// resultCache contains running and finished results by normalized command line
+	resultCache           = map[string]*resultCacheEntry{}
+	resultCacheLock       sync.Mutex
+	resultCacheLastExpire time.Time
+)
+
+// resultCacheRule enables result caching for all commands matching the path prefix
+type resultCacheRule struct {
+	prefix string
+	ttl    time.Duration
+}
+
+// resultCacheEntry is shared by all identical requests, done is closed once the result is available
+type resultCacheEntry struct {
+	done       chan struct{}
+	expires    time.Time
+	valid      bool // false if the command timed out or has been canceled
+	returnCode int
+	output     string
+	rawOutput  string
+}
+
+// parseResultCacheRule parses a result_cache definition like <path prefix>:<ttl>
+func parseResultCacheRule(definition string) (*resultCacheRule, error) {
+	prefix, ttl, ok := strings.Cut(definition, ":")
+	if !ok || !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("invalid result_cache %s, expected <path prefix>:<ttl>", definition)
+	}
+	seconds, err := strconv.Atoi(strings.TrimSpace(ttl))
+	if err != nil || seconds <= 0 {
+		return nil, fmt.Errorf("invalid result_cache ttl in %s, must be a positive number of seconds", definition)
+	}
+
+	return &resultCacheRule{prefix: prefix, ttl: time.Duration(seconds) * time.Second}, nil
+}
+
+// resultCacheTTL returns the cache ttl of the rule with the longest path prefix matching the command line
+func (config *config) resultCacheTTL(cmdString string) time.Duration {
+	if len(config.resultCacheRules) == 0 {
+		return 0
+	}
+	fields := strings.Fields(cmdString)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	var match *resultCacheRule
+	for _, rule := range config.resultCacheRules {
+		if !strings.HasPrefix(fields[0], rule.prefix) {
+			continue
+		}
+		if match == nil || len(rule.prefix) > len(match.prefix) {
+			match = rule
+		}
+	}
+	if match == nil {
+		return 0
+	}
+
+	return match.ttl
+}
+
+// executeCachedCommandLine runs the command unless an identical command is running or has finished within the cache ttl
+func executeCachedCommandLine(result *answer, received *request, config *config) {
+	ttl := config.resultCacheTTL(received.commandLine)
+	if ttl <= 0 {
+		executeCommandLine(result, received, config)
+
+		return
+	}
+
+	key := resultCacheKey(received, config)
+	now := time.Now()
+
+	resultCacheLock.Lock()
+	expireResultCache(now)
+	entry, ok := resultCache[key]
+	if ok && (entry.running() || now.Before(entry.expires)) {
+		resultCacheLock.Unlock()
+		if !waitForCachedResult(result, received, config, entry) {
+			return
+		}
+		if entry.valid {
+			resultCacheHitCounter.Inc()
+			log.Tracef("using cached result for: %s", received.commandLine)
+			result.returnCode = entry.returnCode
+			result.output = entry.output
+			result.rawOutput = entry.rawOutput
+			result.execType = "cached"
+			taskCounter.WithLabelValues(received.typ, result.execType).Inc()
+
+			return
+		}
+
+		// shared run timed out or has been canceled, run again without caching
+		resultCacheMissCounter.Inc()
+		executeCommandLine(result, received, config)
+
+		return
+	}
+	entry = &resultCacheEntry{done: make(chan struct{})}
+	resultCache[key] = entry
+	resultCacheLock.Unlock()
+
+	resultCacheMissCounter.Inc()
+	defer func() {
+		resultCacheLock.Lock()
+		defer resultCacheLock.Unlock()
+
+		entry.returnCode = result.returnCode
+		entry.output = result.output
+		entry.rawOutput = result.rawOutput
+		entry.expires = time.Now().Add(ttl)
+		entry.valid = !result.timedOut && !received.isCanceled() && !received.isAdminCanceled()
+		if !entry.valid && resultCache[key] == entry {
+			delete(resultCache, key)
+		}
+		close(entry.done)
+	}()
+	executeCommandLine(result, received, config)
+}
+
+// resultCacheKey returns the cache key of a request, only requests running the same command
+// with the same user and sandbox share their results
+func resultCacheKey(received *request, config *config) string {
+	runAs := ""
+	if rule := config.runAsRule(received); rule != nil {
+		runAs = fmt.Sprintf("%s:%d:%d:%v", rule.user, rule.uid, rule.gid, rule.groups)
+	}
+	sandbox := ""
+	if profile := config.sandboxProfile(received.commandLine); profile != nil {
+		sandbox = fmt.Sprintf("%s:%t", profile.prefix, profile.noNetwork)
+	}
+
+	// whitespace within the command line might be part of quoted arguments
+	return runAs + "\x00" + sandbox + "\x00" + strings.TrimSpace(received.commandLine)
+}
+
+// waitForCachedResult waits until the shared run has finished. Waiting jobs time out and can
+// be canceled like running commands. Returns false if the result has been set already.
+func waitForCachedResult(result *answer, received *request, config *config, entry *resultCacheEntry) bool {
+	timer := time.NewTimer(time.Duration(received.timeout) * time.Second)
+	defer timer.Stop()
+
+	canceled := make(chan struct{})
+	var once sync.Once
+	received.setCancel(func() {
+		once.Do(func() { close(canceled) })
+	})
+	defer received.setCancel(nil)
+
+	select {
+	case <-entry.done:
+		return true
+	case <-timer.C:
+		result.execType = "cached"
+		taskCounter.WithLabelValues(received.typ, result.execType).Inc()
+		setTimeoutResult(result, config, received, nil)
+	case <-canceled:
+		result.execType = "cached"
+		taskCounter.WithLabelValues(received.typ, result.execType).Inc()
+		if received.isAdminCanceled() {
+			setCancelResult(result, config, received)
+		}
+	}
+
+	return false
+}
+
+// running returns true as long as the result is not available
+func (entry *resultCacheEntry) running() bool {
+	select {
+	case <-entry.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// expireResultCache removes finished and expired entries, must be called with resultCacheLock held
+func expireResultCache(now time.Time) {
+	if now.Sub(resultCacheLastExpire) < resultCacheExpireInterval {
+		return
+	}
+	resultCacheLastExpire = now
+
+	for key, entry := range resultCache {
+		if !entry.running() && now.After(entry.expires) {
+			delete(resultCache, key)
+		}
+	}
+}
+
+// checkResultCacheConfig parses all result_cache definitions
+func checkResultCacheConfig(config *config) error {
+	config.resultCacheRules = nil
+	for _, definition := range config.resultCache {
+		rule, err := parseResultCacheRule(definition)
+		if err != nil {
+			return err
+		}
+		config.resultCacheRules = append(config.resultCacheRules, rule)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package modgearman
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResultCacheRule(t *testing.T) {
+	rule, err := parseResultCacheRule("/usr/lib/nagios/plugins/check_snmp:30")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/lib/nagios/plugins/check_snmp", rule.prefix)
+	assert.Equal(t, 30*time.Second, rule.ttl)
+
+	for _, definition := range []string{"", "/bin/sh", "check_snmp:30", "/bin/sh:0", "/bin/sh:abc"} {
+		_, err = parseResultCacheRule(definition)
+		require.Errorf(t, err, "definition: %s", definition)
+	}
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	cfg := config{resultCache: []string{"/usr/lib/:10", "/usr/lib/nagios/:20"}}
+	require.NoError(t, checkResultCacheConfig(&cfg))
+	assert.Equal(t, 20*time.Second, cfg.resultCacheTTL("/usr/lib/nagios/check_ping -H localhost"))
+	assert.Equal(t, 10*time.Second, cfg.resultCacheTTL("/usr/lib/check_dummy 0"))
+	assert.Equal(t, time.Duration(0), cfg.resultCacheTTL("/bin/sh -c true"))
+	assert.Equal(t, time.Duration(0), cfg.resultCacheTTL(""))
+}
+
+func TestResultCacheConcurrent(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.resultCache = []string{"/bin/sh:60"}
+	require.NoError(t, checkResultCacheConfig(&cfg))
+
+	counter := filepath.Join(t.TempDir(), "counter")
+	cmd := "/bin/sh -c 'echo x >> " + counter + "; wc -l < " + counter + "; sleep 0.3'"
+
+	results := make([]*answer, 5)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = readAndExecute(&request{typ: "service", commandLine: cmd, timeout: 10}, &cfg)
+		}()
+	}
+	wg.Wait()
+
+	cached := 0
+	for _, result := range results {
+		assert.Equal(t, 0, result.returnCode)
+		assert.Equal(t, "1", result.output)
+		if result.execType == "cached" {
+			cached++
+		}
+	}
+	assert.Equal(t, 4, cached)
+
+	// leading and trailing whitespace use the same cache entry
+	result := readAndExecute(&request{typ: "host", commandLine: "  " + cmd, timeout: 10}, &cfg)
+	assert.Equal(t, "1", result.output)
+	assert.Equal(t, "cached", result.execType)
+
+	// expired entries run the command again
+	key := resultCacheKey(&request{commandLine: cmd}, &cfg)
+	resultCacheLock.Lock()
+	resultCache[key].expires = time.Now().Add(-time.Second)
+	resultCacheLock.Unlock()
+	result = readAndExecute(&request{typ: "service", commandLine: cmd, timeout: 10}, &cfg)
+	assert.Equal(t, "2", result.output)
+}
+
+func TestResultCacheTimeout(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.resultCache = []string{"/bin/sh:60"}
+	require.NoError(t, checkResultCacheConfig(&cfg))
+
+	counter := filepath.Join(t.TempDir(), "counter")
+	cmd := "/bin/sh -c 'echo x >> " + counter + "; wc -l < " + counter + "; sleep 3'"
+
+	result := readAndExecute(&request{typ: "service", commandLine: cmd, timeout: 1}, &cfg)
+	assert.Equal(t, 3, result.returnCode)
+
+	// timed out results are not cached
+	resultCacheLock.Lock()
+	_, ok := resultCache[resultCacheKey(&request{commandLine: cmd}, &cfg)]
+	resultCacheLock.Unlock()
+	assert.False(t, ok)
+}
+
+func TestResultCacheKey(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.sandboxes = []string{"/usr/lib/nagios/dmz/:no_network"}
+	require.NoError(t, checkSandboxConfig(&cfg))
+	cfg.runAsRules = []*runAsRule{{match: "dmz", user: "nobody", uid: 65534, gid: 65534}}
+
+	cmd := "/usr/lib/nagios/check_ping -H localhost"
+	key := resultCacheKey(&request{commandLine: cmd}, &cfg)
+	assert.Equal(t, key, resultCacheKey(&request{commandLine: " " + cmd + " ", queue: "service"}, &cfg))
+	assert.NotEqual(t, key, resultCacheKey(&request{commandLine: cmd, queue: "dmz"}, &cfg), "different run_as user")
+	assert.NotEqual(t,
+		resultCacheKey(&request{commandLine: "/usr/lib/nagios/check_x -s 'a  b'"}, &cfg),
+		resultCacheKey(&request{commandLine: "/usr/lib/nagios/check_x -s 'a b'"}, &cfg),
+		"whitespace in quoted arguments")
+
+	sandboxed := "/usr/lib/nagios/dmz/check_ping -H localhost"
+	key = resultCacheKey(&request{commandLine: sandboxed}, &cfg)
+	cfg.sandboxes = nil
+	require.NoError(t, checkSandboxConfig(&cfg))
+	assert.NotEqual(t, key, resultCacheKey(&request{commandLine: sandboxed}, &cfg), "different sandbox")
+}
+
+func TestResultCacheWaiter(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.resultCache = []string{"/bin/sh:60"}
+	require.NoError(t, checkResultCacheConfig(&cfg))
+	cfg.cancelReturn = 2
+
+	cmd := "/bin/sh -c 'sleep 3; echo shared'"
+	done := make(chan *answer, 1)
+	go func() {
+		done <- readAndExecute(&request{typ: "service", commandLine: cmd, timeout: 10}, &cfg)
+	}()
+	key := resultCacheKey(&request{commandLine: cmd}, &cfg)
+	require.Eventually(t, func() bool {
+		resultCacheLock.Lock()
+		defer resultCacheLock.Unlock()
+
+		return resultCache[key] != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// waiting jobs run into their own timeout
+	started := time.Now()
+	result := readAndExecute(&request{typ: "service", commandLine: cmd, timeout: 1}, &cfg)
+	assert.Less(t, time.Since(started), 2*time.Second)
+	assert.True(t, result.timedOut)
+	assert.Equal(t, cfg.timeoutReturn, result.returnCode)
+	assert.Contains(t, result.output, "Timed Out")
+
+	// waiting jobs can be canceled
+	waiter := &request{typ: "service", commandLine: cmd, timeout: 10}
+	canceled := make(chan *answer, 1)
+	go func() {
+		canceled <- readAndExecute(waiter, &cfg)
+	}()
+	require.Eventually(t, waiter.cancelable, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, waiter.Cancel(true))
+	select {
+	case result = <-canceled:
+		assert.Equal(t, 2, result.returnCode)
+		assert.Contains(t, result.output, "Canceled")
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiting job has not been canceled")
+	}
+
+	result = <-done
+	assert.Equal(t, "shared", result.output)
+}
@@ -1,6 +1,7 @@
 package modgearman
 
 import (
+	"fmt"
 	"strings"
 	time "time"
 
@@ -36,6 +37,8 @@ func initializeResultServerConsumers(config *config) {
 	// all result worker share one queue
 	resultServerQueue = make(chan *answer, ResultServerQueueSize) // queue at least 1k results before stalling
 
+	initializeResultSpool(config)
+
 	// create result workers
 	for len(resultServerConsumers) < numResultServer {
 		consumer := &resultServerConsumer{
@@ -55,6 +58,8 @@ func terminateResultServerConsumers() bool {
 		resultServerConsumers[i].terminationRequest <- true
 	}
 	resultServerConsumers = nil
+	terminateResultSpool()
+	spoolPendingResults()
 
 	return true
 }
@@ -71,13 +76,22 @@ func runResultServerConsumer(server *resultServerConsumer) {
 
 			return
 		case result = <-server.queue:
+			// keep results in order as long as there are spooled results waiting for replay
+			if resultServerSpool != nil && resultServerSpool.Len() > 0 && spoolResult(result) {
+				continue
+			}
+
 			var err error
 			var sendSuccess bool
 			var shouldExit bool
 			shouldExit, sendSuccess, curClient, err = sendResult(server, curClient, result)
 
-			if !sendSuccess && err != nil {
-				log.Errorf("failed to send back result: %w", err)
+			if !sendSuccess {
+				if spoolResult(result) {
+					log.Warnf("failed to send back result, spooled for later delivery: %w", err)
+				} else if err != nil {
+					log.Errorf("failed to send back result: %w", err)
+				}
 			}
 			if shouldExit {
 				return
@@ -90,20 +104,16 @@ func sendResult(server *resultServerConsumer, curClient *client.Client, result *
 	// send result back to any server
 	success = false
 	retries := 0
+	span := startResultSpan(result, "sendResult")
+	defer func() {
+		span.SetAttributes(traceAttrRetries.Int(retries))
+		setSpanError(span, err)
+		span.End()
+	}()
 	for {
-		var clt *client.Client
-		for _, address := range server.config.server {
-			clt, err = sendAnswer(curClient, result, address, server.config.encryption, defaultClientTimeout)
-			if err == nil {
-				curClient = clt
-				success = true
-
-				break
-			}
-			curClient = nil
-			if clt != nil {
-				clt.Close()
-			}
+		curClient, err = sendAnswerToAnyServer(curClient, result, server.config)
+		if err == nil {
+			success = true
 		}
 		if success || retries > 120 {
 			break
@@ -135,7 +145,31 @@ func sendResult(server *resultServerConsumer, curClient *client.Client, result *
 	return shouldExit, success, retClient, err
 }
 
+// resultSendFunc sends a single result and returns the client for reuse
+type resultSendFunc func(clt *client.Client, result *answer) (*client.Client, error)
+
+// sendAnswerToAnyServer sends the result to the first server accepting it
+func sendAnswerToAnyServer(curClient *client.Client, result *answer, config *config) (*client.Client, error) {
+	err := fmt.Errorf("no result server configured")
+	for _, address := range config.server {
+		var clt *client.Client
+		clt, err = sendAnswer(curClient, result, address, config.encryption, defaultClientTimeout)
+		if err == nil {
+			return clt, nil
+		}
+		curClient = nil
+		if clt != nil {
+			clt.Close()
+		}
+	}
+
+	return nil, err
+}
+
 func enqueueServerResult(result *answer) {
+	span := startResultSpan(result, "enqueueServerResult")
+	defer span.End()
+
 	// since it is a shared queue, we simply use the first one
 	resultServerQueue <- result
 }
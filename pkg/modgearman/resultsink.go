@@ -0,0 +1,291 @@
+package modgearman
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	time "time"
+)
+
+const (
+	// ResultSinkQueueSize sets the queue size for each result sink
+	ResultSinkQueueSize = 1000
+
+	// resultSinkTimeout sets the timeout for sending a result to a sink
+	resultSinkTimeout = 10 * time.Second
+)
+
+// resultSink receives a copy of every check result in addition to the gearman result queue
+type resultSink interface {
+	// Send delivers a single result
+	Send(result *answer) error
+	// Close releases all resources of the sink
+	Close() error
+}
+
+type resultSinkConsumer struct {
+	name               string
+	sink               resultSink
+	queue              chan *answer
+	terminationRequest chan bool
+}
+
+var resultSinkConsumers []*resultSinkConsumer
+
+// newResultSink creates a result sink from its configuration value
+// supported formats are:
+//   - http://host:port/path or https://... (POST each result as json document)
+//   - file:///path/results.jsonl (append json lines to file)
+//   - unix:///path/socket (write json lines to unix domain socket)
+func newResultSink(definition string) (resultSink, error) {
+	scheme, target, ok := strings.Cut(definition, "://")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("invalid result_sink %s, expected <scheme>://<target>", redactURL(definition))
+	}
+
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		if _, err := url.Parse(definition); err != nil {
+			// the url error contains the full url including credentials
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+
+			return nil, fmt.Errorf("invalid result_sink %s: %s", redactURL(definition), err.Error())
+		}
+
+		return &resultSinkHTTP{
+			url:    definition,
+			client: &http.Client{Timeout: resultSinkTimeout},
+		}, nil
+	case "file":
+		return &resultSinkFile{path: target}, nil
+	case "unix":
+		return &resultSinkUnix{path: target}, nil
+	default:
+		return nil, fmt.Errorf("unsupported result_sink scheme %s in %s", scheme, redactURL(definition))
+	}
+}
+
+func initializeResultSinks(config *config) {
+	resultSinkConsumers = make([]*resultSinkConsumer, 0, len(config.resultSinks))
+	for _, definition := range config.resultSinks {
+		sink, err := newResultSink(definition)
+		if err != nil {
+			log.Errorf("result sink disabled: %s", err.Error())
+
+			continue
+		}
+		// the name is used as metric label, so it must not contain credentials
+		name := redactURL(definition)
+		log.Debugf("creating result sink for: %s", name)
+		consumer := &resultSinkConsumer{
+			name:               name,
+			sink:               sink,
+			queue:              make(chan *answer, ResultSinkQueueSize),
+			terminationRequest: make(chan bool),
+		}
+		resultSinkConsumers = append(resultSinkConsumers, consumer)
+		go func() {
+			defer logPanicExit()
+			runResultSinkConsumer(consumer)
+		}()
+	}
+}
+
+func terminateResultSinks() {
+	log.Debugf("Terminating result sinks")
+	for _, consumer := range resultSinkConsumers {
+		consumer.terminationRequest <- true
+	}
+	resultSinkConsumers = nil
+}
+
+func runResultSinkConsumer(consumer *resultSinkConsumer) {
+	defer func() {
+		logDebug(consumer.sink.Close())
+	}()
+	for {
+		select {
+		case <-consumer.terminationRequest:
+			// flush remaining results
+			for {
+				select {
+				case result := <-consumer.queue:
+					consumer.send(result)
+				default:
+					return
+				}
+			}
+		case result := <-consumer.queue:
+			consumer.send(result)
+		}
+	}
+}
+
+func (consumer *resultSinkConsumer) send(result *answer) {
+	err := consumer.sink.Send(result)
+	if err != nil {
+		resultSinkErrorCounter.WithLabelValues(consumer.name).Inc()
+		log.Debugf("failed to send result to sink %s: %s", consumer.name, err.Error())
+	}
+}
+
+// enqueueResultSinks passes a copy of the result to all configured sinks
+func enqueueResultSinks(result *answer) {
+	if len(resultSinkConsumers) == 0 {
+		return
+	}
+	sinkResult := *result
+
+	for _, consumer := range resultSinkConsumers {
+		select {
+		case consumer.queue <- &sinkResult:
+		default:
+			resultSinkErrorCounter.WithLabelValues(consumer.name).Inc()
+			log.Debugf("channel is at capacity (%d), dropping message (to result sink): %s", ResultSinkQueueSize, consumer.name)
+		}
+	}
+}
+
+func marshalResultSinkLine(result *answer) ([]byte, error) {
+	data, err := json.Marshal(newAnswerJSON(result))
+	if err != nil {
+		return nil, fmt.Errorf("json error: %w", err)
+	}
+
+	return append(data, '\n'), nil
+}
+
+// resultSinkHTTP posts each result as json document
+type resultSinkHTTP struct {
+	url    string
+	client *http.Client
+}
+
+func (s *resultSinkHTTP) Send(result *answer) error {
+	data, err := json.Marshal(newAnswerJSON(result))
+	if err != nil {
+		return fmt.Errorf("json error: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resultSinkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", redactURLError(err, s.url))
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("http post: unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *resultSinkHTTP) Close() error {
+	s.client.CloseIdleConnections()
+
+	return nil
+}
+
+// resultSinkFile appends each result as json line to a file
+type resultSinkFile struct {
+	path string
+	file *os.File
+}
+
+func (s *resultSinkFile) Send(result *answer) error {
+	line, err := marshalResultSinkLine(result)
+	if err != nil {
+		return err
+	}
+
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("cannot open %s: %w", s.path, err)
+		}
+		s.file = file
+	}
+
+	_, err = s.file.Write(line)
+	if err != nil {
+		s.file.Close()
+		s.file = nil
+
+		return fmt.Errorf("cannot write to %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+func (s *resultSinkFile) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// resultSinkUnix writes each result as json line to a unix domain socket
+type resultSinkUnix struct {
+	path string
+	conn net.Conn
+}
+
+func (s *resultSinkUnix) Send(result *answer) error {
+	line, err := marshalResultSinkLine(result)
+	if err != nil {
+		return err
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("unix", s.path, resultSinkTimeout)
+		if err != nil {
+			return fmt.Errorf("cannot connect to %s: %w", s.path, err)
+		}
+		s.conn = conn
+	}
+
+	logDebug(s.conn.SetWriteDeadline(time.Now().Add(resultSinkTimeout)))
+	_, err = s.conn.Write(line)
+	if err != nil {
+		s.conn.Close()
+		s.conn = nil
+
+		return fmt.Errorf("cannot write to %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+func (s *resultSinkUnix) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
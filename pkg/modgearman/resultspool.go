@@ -0,0 +1,424 @@
+package modgearman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	time "time"
+
+	"github.com/appscode/g2/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// resultSpoolSuffix is the file extension used for spooled results
+	resultSpoolSuffix = ".result"
+
+	// resultSpoolReplayInterval sets the interval at which spooled results are replayed
+	resultSpoolReplayInterval = ConnectionRetryInterval * time.Second
+)
+
+// overflow policies for result backlogs
+const (
+	backlogOverflowDropOldest = "drop_oldest"
+	backlogOverflowDropNewest = "drop_newest"
+	backlogOverflowBlock      = "block"
+)
+
+var errResultSpoolFull = errors.New("result spool is full")
+
+// resultSpool stores results which could not be delivered in a local folder
+// and replays them once a result server accepts them again
+type resultSpool struct {
+	dir                string
+	maxSize            int64
+	maxAge             time.Duration
+	overflow           string // one of the backlogOverflow* policies
+	lock               sync.Mutex
+	entries            []*resultSpoolEntry // sorted by age, oldest first
+	size               int64
+	seq                uint64
+	terminationRequest chan bool
+	entriesGauge       prometheus.Gauge
+	oldestGauge        prometheus.Gauge
+	droppedCounter     prometheus.Counter
+}
+
+type resultSpoolEntry struct {
+	name    string
+	size    int64
+	created time.Time
+}
+
+var resultServerSpool *resultSpool
+
+func newResultSpool(dir string, maxSize int64, maxAge time.Duration) (*resultSpool, error) {
+	spool := &resultSpool{
+		dir:                dir,
+		maxSize:            maxSize,
+		maxAge:             maxAge,
+		overflow:           backlogOverflowDropOldest,
+		terminationRequest: make(chan bool),
+	}
+
+	if err := os.MkdirAll(spool.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create result spool folder %s: %w", spool.dir, err)
+	}
+
+	if err := spool.load(); err != nil {
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+func initializeResultSpool(config *config) {
+	resultServerSpool = nil
+	if config.resultSpoolDir == "" {
+		return
+	}
+
+	spool, err := newResultSpool(
+		config.resultSpoolDir,
+		config.resultSpoolMaxSize*1024*1024,
+		time.Duration(config.resultSpoolMaxAge)*time.Second,
+	)
+	if err != nil {
+		log.Errorf("result spool disabled: %s", err.Error())
+
+		return
+	}
+	spool.setMetrics(resultSpoolEntries, resultSpoolOldestAge, nil)
+	if spool.Len() > 0 {
+		log.Infof("found %d spooled result(s) in %s, will be replayed", spool.Len(), spool.dir)
+	}
+	resultServerSpool = spool
+
+	go func() {
+		defer logPanicExit()
+		runResultSpoolReplay(spool, config)
+	}()
+}
+
+func terminateResultSpool() {
+	if resultServerSpool == nil {
+		return
+	}
+	log.Debugf("Terminating result spool replay")
+	resultServerSpool.terminationRequest <- true
+}
+
+// load reads existing entries from the spool folder
+func (s *resultSpool) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read result spool folder %s: %w", s.dir, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entries = make([]*resultSpoolEntry, 0, len(files))
+	s.size = 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		name := file.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// leftover from an interrupted write
+			logDebug(os.Remove(filepath.Join(s.dir, name)))
+
+			continue
+		}
+		if !strings.HasSuffix(name, resultSpoolSuffix) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		s.entries = append(s.entries, &resultSpoolEntry{
+			name:    name,
+			size:    info.Size(),
+			created: info.ModTime(),
+		})
+		s.size += info.Size()
+	}
+
+	// file names start with a zero padded timestamp, so sorting by name keeps the original order
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	s.updateMetrics()
+
+	return nil
+}
+
+// Add writes the result to the spool folder
+func (s *resultSpool) Add(result *answer) error {
+	data, err := json.Marshal(newAnswerJSON(result))
+	if err != nil {
+		return fmt.Errorf("json error: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.fits(int64(len(data))) {
+		// with overflow policy block the caller keeps the result and retries later
+		if s.overflow != backlogOverflowBlock && s.droppedCounter != nil {
+			s.droppedCounter.Inc()
+		}
+
+		return errResultSpoolFull
+	}
+
+	now := time.Now()
+	s.seq++
+	name := fmt.Sprintf("%020d-%08d%s", now.UnixNano(), s.seq, resultSpoolSuffix)
+	file := filepath.Join(s.dir, name)
+
+	// write to temporary file first, so we never replay partially written results
+	if err := os.WriteFile(file+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("cannot write spool file: %w", err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		logDebug(os.Remove(file + ".tmp"))
+
+		return fmt.Errorf("cannot write spool file: %w", err)
+	}
+
+	s.entries = append(s.entries, &resultSpoolEntry{
+		name:    name,
+		size:    int64(len(data)),
+		created: now,
+	})
+	s.size += int64(len(data))
+	s.enforceLimits()
+	s.updateMetrics()
+
+	return nil
+}
+
+// fits returns true if data of the given size can be added without exceeding the size limit, lock must be held
+func (s *resultSpool) fits(size int64) bool {
+	return s.overflow == backlogOverflowDropOldest || s.maxSize <= 0 || s.size+size <= s.maxSize
+}
+
+// Len returns the number of spooled results
+func (s *resultSpool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.entries)
+}
+
+// Oldest returns the oldest spooled result without removing it
+func (s *resultSpool) Oldest() (*resultSpoolEntry, *answer, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enforceLimits()
+	s.updateMetrics()
+	if len(s.entries) == 0 {
+		return nil, nil, nil
+	}
+
+	entry := s.entries[0]
+	data, err := os.ReadFile(filepath.Join(s.dir, entry.name))
+	if err != nil {
+		s.removeEntry(entry)
+
+		return nil, nil, fmt.Errorf("cannot read spool file %s: %w", entry.name, err)
+	}
+
+	spooled := answerJSON{}
+	if err := json.Unmarshal(data, &spooled); err != nil {
+		s.removeEntry(entry)
+
+		return nil, nil, fmt.Errorf("cannot parse spool file %s: %w", entry.name, err)
+	}
+
+	return entry, spooled.answer(), nil
+}
+
+// Remove deletes the given entry from the spool
+func (s *resultSpool) Remove(entry *resultSpoolEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeEntry(entry)
+	s.updateMetrics()
+}
+
+// removeEntry removes the entry, lock must be held
+func (s *resultSpool) removeEntry(entry *resultSpoolEntry) {
+	for i, e := range s.entries {
+		if e == entry {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			s.size -= entry.size
+
+			break
+		}
+	}
+	err := os.Remove(filepath.Join(s.dir, entry.name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warnf("cannot remove spool file %s: %s", entry.name, err.Error())
+	}
+}
+
+// enforceLimits drops entries exceeding the age or size limit, lock must be held
+func (s *resultSpool) enforceLimits() {
+	if s.maxAge > 0 {
+		expired := 0
+		for len(s.entries) > 0 && time.Since(s.entries[0].created) > s.maxAge {
+			s.removeEntry(s.entries[0])
+			expired++
+		}
+		if expired > 0 {
+			log.Warnf("dropped %d spooled result(s) from %s older than %s", expired, s.dir, s.maxAge)
+			if s.droppedCounter != nil {
+				s.droppedCounter.Add(float64(expired))
+			}
+		}
+	}
+
+	if s.maxSize > 0 {
+		dropped := 0
+		for len(s.entries) > 1 && s.size > s.maxSize {
+			s.removeEntry(s.entries[0])
+			dropped++
+		}
+		if dropped > 0 {
+			log.Warnf("result spool %s exceeds size limit of %s, dropped %d oldest result(s)",
+				s.dir, bytes2Human(uint64(s.maxSize)), dropped)
+			if s.droppedCounter != nil {
+				s.droppedCounter.Add(float64(dropped))
+			}
+		}
+	}
+}
+
+// setMetrics sets the prometheus metrics updated by this spool, nil values are ignored
+func (s *resultSpool) setMetrics(entries, oldest prometheus.Gauge, dropped prometheus.Counter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.entriesGauge = entries
+	s.oldestGauge = oldest
+	s.droppedCounter = dropped
+	s.updateMetrics()
+}
+
+// updateMetrics updates the spool gauges, lock must be held
+func (s *resultSpool) updateMetrics() {
+	if s.entriesGauge != nil {
+		s.entriesGauge.Set(float64(len(s.entries)))
+	}
+	if s.oldestGauge == nil {
+		return
+	}
+	if len(s.entries) == 0 {
+		s.oldestGauge.Set(0)
+
+		return
+	}
+	s.oldestGauge.Set(time.Since(s.entries[0].created).Seconds())
+}
+
+// runResultSpoolReplay regularly tries to send back spooled results in order
+func runResultSpoolReplay(spool *resultSpool, config *config) {
+	var curClient *client.Client
+	ticker := time.NewTicker(resultSpoolReplayInterval)
+	defer ticker.Stop()
+	send := func(clt *client.Client, result *answer) (*client.Client, error) {
+		return sendAnswerToAnyServer(clt, result, config)
+	}
+	for {
+		select {
+		case <-spool.terminationRequest:
+			if curClient != nil {
+				curClient.Close()
+			}
+
+			return
+		case <-ticker.C:
+			curClient, _ = replaySpooledResults(spool, send, curClient)
+		}
+	}
+}
+
+// replaySpooledResults sends spooled results until the spool is empty or sending fails
+func replaySpooledResults(spool *resultSpool, send resultSendFunc, curClient *client.Client) (retClient *client.Client, replayed int) {
+	defer func() {
+		if replayed > 0 {
+			log.Infof("replayed %d spooled result(s) from %s, %d remaining", replayed, spool.dir, spool.Len())
+		}
+	}()
+	for isRunning() {
+		entry, result, err := spool.Oldest()
+		if err != nil {
+			log.Warnf("skipping broken spooled result: %s", err.Error())
+
+			continue
+		}
+		if entry == nil {
+			return curClient, replayed
+		}
+
+		curClient, err = send(curClient, result)
+		if err != nil {
+			log.Tracef("replaying spooled result failed: %s", err.Error())
+
+			return curClient, replayed
+		}
+		spool.Remove(entry)
+		replayed++
+	}
+
+	return curClient, replayed
+}
+
+// spoolResult stores the result in the spool if enabled, returns false if result has been dropped
+func spoolResult(result *answer) bool {
+	if resultServerSpool == nil {
+		return false
+	}
+
+	err := resultServerSpool.Add(result)
+	if err != nil {
+		log.Errorf("failed to spool result: %s", err.Error())
+
+		return false
+	}
+	log.Debugf("spooled undeliverable result for host: %s service: %s", result.hostName, result.serviceDescription)
+
+	return true
+}
+
+// spoolPendingResults moves all results still waiting in the result queue into the spool
+func spoolPendingResults() {
+	if resultServerSpool == nil {
+		return
+	}
+
+	spooled := 0
+	for {
+		select {
+		case result := <-resultServerQueue:
+			if spoolResult(result) {
+				spooled++
+			}
+		default:
+			if spooled > 0 {
+				log.Infof("spooled %d pending result(s) to %s", spooled, resultServerSpool.dir)
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package modgearman
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultSpool(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.resultSpoolDir = t.TempDir()
+
+	spool, err := newResultSpool(cfg.resultSpoolDir, 1024*1024, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, spool.Len())
+
+	for _, host := range []string{"host1", "host2", "host3"} {
+		require.NoError(t, spool.Add(&answer{hostName: host, output: "OK - test", resultQueue: "check_results"}))
+	}
+	assert.Equal(t, 3, spool.Len())
+
+	// reload from disk keeps order
+	spool, err = newResultSpool(cfg.resultSpoolDir, 1024*1024, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, spool.Len())
+
+	for _, host := range []string{"host1", "host2", "host3"} {
+		entry, result, err := spool.Oldest()
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		assert.Equal(t, host, result.hostName)
+		assert.Equal(t, "OK - test", result.output)
+		assert.Equal(t, "check_results", result.resultQueue)
+		spool.Remove(entry)
+	}
+	assert.Equal(t, 0, spool.Len())
+
+	files, err := os.ReadDir(cfg.resultSpoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestResultSpoolLimits(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.resultSpoolDir = t.TempDir()
+
+	// leftover temporary files are removed
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.resultSpoolDir, "broken.result.tmp"), []byte("{"), 0o644))
+
+	spool, err := newResultSpool(cfg.resultSpoolDir, 1024*1024, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, spool.Len())
+	_, err = os.Stat(filepath.Join(cfg.resultSpoolDir, "broken.result.tmp"))
+	assert.True(t, os.IsNotExist(err))
+
+	// size limit drops oldest entries
+	spool.maxSize = 400
+	for _, host := range []string{"host1", "host2", "host3", "host4"} {
+		require.NoError(t, spool.Add(&answer{hostName: host}))
+	}
+	assert.Less(t, spool.Len(), 4)
+	_, result, err := spool.Oldest()
+	require.NoError(t, err)
+	assert.NotEqual(t, "host1", result.hostName)
+
+	// age limit drops expired entries
+	spool.maxAge = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	entry, result, err := spool.Oldest()
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+	assert.Nil(t, result)
+	assert.Equal(t, 0, spool.Len())
+
+	// drop newest policy refuses new results once full
+	spool.maxAge = time.Hour
+	spool.maxSize = 200
+	spool.overflow = backlogOverflowDropNewest
+	require.NoError(t, spool.Add(&answer{hostName: "host1"}))
+	require.ErrorIs(t, spool.Add(&answer{hostName: "host2"}), errResultSpoolFull)
+	_, result, err = spool.Oldest()
+	require.NoError(t, err)
+	assert.Equal(t, "host1", result.hostName)
+}
@@ -0,0 +1,181 @@
+package modgearman
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rlimitInfinity is used for unlimited resource limits
+	rlimitInfinity = math.MaxUint64
+
+	// rlimitExecArg is used to re-execute the worker binary as rlimit helper for a plugin
+	rlimitExecArg = "__mod_gearman_rlimit_exec"
+)
+
+// rlimitNames contains all supported plugin resource limits
+var rlimitNames = []string{"cpu", "as", "nofile", "nproc", "core"}
+
+// rlimitRule contains resource limits for all plugins matching the path prefix, an empty prefix matches all plugins
+type rlimitRule struct {
+	prefix string
+	limits map[string]uint64
+}
+
+// parseRlimitRule parses a rlimit definition like [<path prefix>:]<name>=<value>[,<name>=<value>...]
+func parseRlimitRule(definition string) (*rlimitRule, error) {
+	rule := &rlimitRule{limits: map[string]uint64{}}
+	list := definition
+	if strings.HasPrefix(definition, "/") {
+		prefix, rest, ok := strings.Cut(definition, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rlimit %s, expected [<path prefix>:]<name>=<value>", definition)
+		}
+		rule.prefix = prefix
+		list = rest
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rlimit %s, expected [<path prefix>:]<name>=<value>", definition)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isValidRlimitName(name) {
+			return nil, fmt.Errorf("unknown rlimit %s in %s, supported limits are: %s", name, definition, strings.Join(rlimitNames, ", "))
+		}
+		num, err := parseRlimitValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rlimit value in %s: %s", definition, err.Error())
+		}
+		rule.limits[name] = num
+	}
+
+	return rule, nil
+}
+
+func isValidRlimitName(name string) bool {
+	for _, valid := range rlimitNames {
+		if name == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRlimitValue parses numbers with an optional K, M or G suffix or unlimited
+func parseRlimitValue(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "unlimited") {
+		return rlimitInfinity, nil
+	}
+
+	multiplier := uint64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1024
+		case 'm', 'M':
+			multiplier = 1024 * 1024
+		case 'g', 'G':
+			multiplier = 1024 * 1024 * 1024
+		}
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	num, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a positive number", value)
+	}
+	if num > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("%s is too large", value)
+	}
+
+	return num * multiplier, nil
+}
+
+// pluginRlimits returns the resource limits for the given command line. Global limits
+// apply first, rules with longer matching path prefixes override shorter ones.
+func (config *config) pluginRlimits(cmdString string) map[string]uint64 {
+	if len(config.rlimitRules) == 0 {
+		return nil
+	}
+	fields := strings.Fields(cmdString)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	rules := []*rlimitRule{}
+	for _, rule := range config.rlimitRules {
+		if strings.HasPrefix(fields[0], rule.prefix) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].prefix) < len(rules[j].prefix) })
+
+	limits := map[string]uint64{}
+	for _, rule := range rules {
+		for name, value := range rule.limits {
+			limits[name] = value
+		}
+	}
+
+	return limits
+}
+
+// checkRlimitConfig parses all rlimit definitions
+func checkRlimitConfig(config *config) error {
+	config.rlimitRules = nil
+	for _, definition := range config.rlimits {
+		rule, err := parseRlimitRule(definition)
+		if err != nil {
+			return err
+		}
+		if _, ok := rule.limits["nproc"]; ok && len(config.runAs) == 0 {
+			log.Warnf("rlimit nproc counts all processes of the user, without run_as it is shared with the worker and all other plugins: %s", definition)
+		}
+		config.rlimitRules = append(config.rlimitRules, rule)
+	}
+	if len(config.rlimitRules) > 0 && runtime.GOOS != "linux" {
+		log.Warnf("rlimit is only supported on linux")
+	}
+
+	return nil
+}
+
+// formatRlimit returns a human readable limit
+func formatRlimit(name string, value uint64) string {
+	switch {
+	case value == rlimitInfinity:
+		return "unlimited"
+	case name == "cpu":
+		return fmt.Sprintf("%ds", value)
+	case name == "as":
+		return bytes2Human(value)
+	default:
+		return strconv.FormatUint(value, 10)
+	}
+}
+
+// formatRlimitArg returns the limits in the rlimit definition format passed to the rlimit helper
+func formatRlimitArg(limits map[string]uint64) string {
+	list := make([]string, 0, len(limits))
+	for name, value := range limits {
+		if value == rlimitInfinity {
+			list = append(list, name+"=unlimited")
+
+			continue
+		}
+		list = append(list, name+"="+strconv.FormatUint(value, 10))
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,135 @@
+package modgearman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var rlimitResources = map[string]int{
+	"cpu":    unix.RLIMIT_CPU,
+	"as":     unix.RLIMIT_AS,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"core":   unix.RLIMIT_CORE,
+}
+
+// the worker binary is re-executed to set the resource limits before starting the plugin
+func init() {
+	if len(os.Args) > 3 && os.Args[1] == rlimitExecArg {
+		rlimitExec(os.Args[2], os.Args[3], os.Args[4:])
+	}
+}
+
+// applyRlimits starts the command through the rlimit helper, so the limits apply
+// before the plugin is executed and are inherited by all its child processes
+func applyRlimits(cmd *exec.Cmd, limits map[string]uint64) {
+	if len(limits) == 0 {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		cmd.Err = fmt.Errorf("cannot set rlimits: %s", err.Error())
+
+		return
+	}
+	cmd.Args = append([]string{exe, rlimitExecArg, formatRlimitArg(limits), cmd.Path}, cmd.Args...)
+	// the run_as user might not be allowed to access the folder of the worker binary
+	cmd.Path = selfExe
+}
+
+// rlimitExec sets the resource limits and replaces itself with the plugin, it never returns
+func rlimitExec(limitArg, path string, args []string) {
+	rule, err := parseRlimitRule(limitArg)
+	if err != nil {
+		rlimitExit(ExitCodeUnknown, err)
+	}
+
+	// prepare the exec arguments upfront, allocating memory might fail once the limits are set
+	argv0, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		rlimitExit(ExitCodeUnknown, err)
+	}
+	argv, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		rlimitExit(ExitCodeUnknown, err)
+	}
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		rlimitExit(ExitCodeUnknown, err)
+	}
+
+	if err := setRlimits(rule.limits); err != nil {
+		rlimitExit(ExitCodeUnknown, err)
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE,
+		uintptr(unsafe.Pointer(argv0)),
+		uintptr(unsafe.Pointer(&argv[0])),
+		uintptr(unsafe.Pointer(&envv[0])))
+	switch {
+	case errors.Is(errno, syscall.ENOENT):
+		rlimitExit(exitCodeFileNotFound, errno)
+	case errors.Is(errno, syscall.EACCES), errors.Is(errno, syscall.EPERM):
+		rlimitExit(exitCodeNotExecutable, errno)
+	}
+	rlimitExit(ExitCodeUnknown, errno)
+}
+
+func rlimitExit(code int, err error) {
+	fmt.Fprintf(os.Stderr, "UNKNOWN: rlimit setup failed: %s\n", err.Error())
+	os.Exit(code)
+}
+
+// setRlimits sets the resource limits of the current process
+func setRlimits(limits map[string]uint64) error {
+	for name, value := range limits {
+		limit := &unix.Rlimit{Cur: value, Max: value}
+		if value == rlimitInfinity {
+			limit = &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}
+		} else if name == "cpu" {
+			// send SIGXCPU first and SIGKILL one second later
+			limit.Max = value + 1
+		}
+		if err := unix.Setrlimit(rlimitResources[name], limit); err != nil {
+			return fmt.Errorf("setting rlimit %s to %s failed: %w", name, formatRlimit(name, value), err)
+		}
+	}
+
+	return nil
+}
+
+// exceededRlimit returns a message naming the resource limit which killed the plugin
+func exceededRlimit(result *answer, state *os.ProcessState, limits map[string]uint64) string {
+	if len(limits) == 0 || state == nil || result.returnCode == 0 {
+		return ""
+	}
+	waitStatus, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return ""
+	}
+
+	if cpu, ok := limits["cpu"]; ok && cpu != rlimitInfinity {
+		used := state.UserTime() + state.SystemTime()
+		if waitStatus.Signal() == syscall.SIGXCPU || (waitStatus.Signal() == syscall.SIGKILL && used >= time.Duration(cpu)*time.Second) {
+			return fmt.Sprintf("Plugin has been killed after exceeding the cpu time limit of %s (rlimit cpu)", formatRlimit("cpu", cpu))
+		}
+	}
+
+	// failed allocations usually end in an abort or a segfault when growing the stack
+	if as, ok := limits["as"]; ok && as != rlimitInfinity {
+		switch waitStatus.Signal() {
+		case syscall.SIGSEGV, syscall.SIGABRT, syscall.SIGBUS:
+			return fmt.Sprintf("Plugin has been killed by signal %s, the address space limit of %s has probably been reached (rlimit as)",
+				waitStatus.Signal(), formatRlimit("as", as))
+		}
+	}
+
+	return ""
+}
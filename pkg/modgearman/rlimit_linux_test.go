@@ -0,0 +1,83 @@
+package modgearman
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRlimitApplied(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.rlimits = []string{"nofile=64"}
+	require.NoError(t, checkRlimitConfig(&cfg))
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'ulimit -n'", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, "64", result.output)
+}
+
+func TestRlimitCPUExceeded(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.rlimits = []string{"cpu=1"}
+	require.NoError(t, checkRlimitConfig(&cfg))
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'while :; do :; done'", timeout: 10}, &cfg)
+	assert.Equal(t, 3, result.returnCode)
+	assert.Contains(t, result.output, "UNKNOWN: Plugin has been killed after exceeding the cpu time limit of 1s (rlimit cpu). (worker: testworker)")
+}
+
+func TestRlimitOutOfMemory(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.rlimits = []string{"as=64M"}
+	require.NoError(t, checkRlimitConfig(&cfg))
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'kill -SEGV $$'", timeout: 10}, &cfg)
+	assert.Equal(t, 3, result.returnCode)
+	assert.Contains(t, result.output, "UNKNOWN: Plugin has been killed by signal segmentation fault, the address space limit of 64.00 MB has probably been reached (rlimit as). (worker: testworker)")
+
+	// regular critical results mentioning memory are not changed
+	result = readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'echo \"CRITICAL - out of memory\"; exit 2'", timeout: 10}, &cfg)
+	assert.Equal(t, 2, result.returnCode)
+	assert.Equal(t, "CRITICAL - out of memory", result.output)
+}
+
+func TestRlimitNotExecutable(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.rlimits = []string{"nofile=64"}
+	require.NoError(t, checkRlimitConfig(&cfg))
+
+	plugin := filepath.Join(t.TempDir(), "check_test")
+	require.NoError(t, os.WriteFile(plugin, []byte("#!/bin/sh\necho OK\n"), 0o644))
+
+	result := readAndExecute(&request{typ: "service", commandLine: plugin, timeout: 10}, &cfg)
+	assert.Equal(t, 3, result.returnCode)
+	assert.Contains(t, result.output, "Make sure the plugin you're trying to run is executable")
+}
+
+func TestRlimitRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.rlimits = []string{"/bin/:nofile=64"}
+	require.NoError(t, checkRlimitConfig(&cfg))
+	nobody := sandboxNobody()
+	cfg.runAsRules = []*runAsRule{{match: "/bin/", user: "nobody", uid: nobody.uid, gid: nobody.gid}}
+
+	// the run_as user cannot access the folder of the test binary
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'ulimit -n'", timeout: 10}, &cfg)
+	require.Equal(t, 0, result.returnCode, result.output)
+	assert.Equal(t, "64", result.output)
+}
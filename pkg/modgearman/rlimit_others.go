@@ -0,0 +1,16 @@
+//go:build !linux
+
+package modgearman
+
+import (
+	"os"
+	"os/exec"
+)
+
+func applyRlimits(_ *exec.Cmd, _ map[string]uint64) {
+	// rlimits are only supported on linux
+}
+
+func exceededRlimit(_ *answer, _ *os.ProcessState, _ map[string]uint64) string {
+	return ""
+}
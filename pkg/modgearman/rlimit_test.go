@@ -0,0 +1,48 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRlimitRule(t *testing.T) {
+	rule, err := parseRlimitRule("cpu=60, as=512M,core=0")
+	require.NoError(t, err)
+	assert.Empty(t, rule.prefix)
+	assert.Equal(t, map[string]uint64{"cpu": 60, "as": 512 * 1024 * 1024, "core": 0}, rule.limits)
+
+	rule, err = parseRlimitRule("/usr/local/plugins/check_big:as=2g,nofile=unlimited")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/local/plugins/check_big", rule.prefix)
+	assert.Equal(t, map[string]uint64{"as": 2 * 1024 * 1024 * 1024, "nofile": rlimitInfinity}, rule.limits)
+
+	for _, definition := range []string{"cpu", "stack=10", "cpu=-1", "as=1T", "as=99999999999G", "/usr/local/plugins/check_big", "nproc="} {
+		_, err := parseRlimitRule(definition)
+		require.Errorf(t, err, "parsing %s", definition)
+	}
+}
+
+func TestPluginRlimits(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	assert.Nil(t, cfg.pluginRlimits("/usr/lib/plugins/check_ping -H localhost"))
+
+	cfg.rlimits = []string{
+		"/usr/lib/plugins/check_big:as=4G,cpu=600",
+		"cpu=60,as=1G,core=0",
+		"/usr/lib/plugins/:cpu=120",
+	}
+	require.NoError(t, checkRlimitConfig(&cfg))
+
+	assert.Equal(t, map[string]uint64{"cpu": 120, "as": 1024 * 1024 * 1024, "core": 0},
+		cfg.pluginRlimits("/usr/lib/plugins/check_ping -H localhost"))
+	assert.Equal(t, map[string]uint64{"cpu": 600, "as": 4 * 1024 * 1024 * 1024, "core": 0},
+		cfg.pluginRlimits("/usr/lib/plugins/check_big -w 1"))
+	assert.Equal(t, map[string]uint64{"cpu": 60, "as": 1024 * 1024 * 1024, "core": 0},
+		cfg.pluginRlimits("/opt/plugins/check_other"))
+
+	cfg.rlimits = append(cfg.rlimits, "unknown=1")
+	require.Error(t, checkRlimitConfig(&cfg))
+}
@@ -0,0 +1,145 @@
+package modgearman
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// runAsRule maps plugins by command path prefix or queue to another user
+type runAsRule struct {
+	match  string // path prefix (starting with /) or queue name
+	user   string
+	uid    uint32
+	gid    uint32
+	groups []uint32
+}
+
+// parseRunAsRule parses a run_as definition like <path prefix|queue>:<user>[:<group>[:<group>,...]]
+func parseRunAsRule(definition string) (*runAsRule, error) {
+	parts := strings.Split(definition, ":")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid run_as %s, expected <path prefix|queue>:<user>[:<group>[:<groups>]]", definition)
+	}
+
+	usr, err := lookupRunAsUser(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid run_as %s: %s", definition, err.Error())
+	}
+	rule := &runAsRule{
+		match: parts[0],
+		user:  usr.Username,
+	}
+	rule.uid, err = parseRunAsID(usr.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run_as %s: %s", definition, err.Error())
+	}
+
+	// primary group defaults to the users group
+	group := usr.Gid
+	if len(parts) > 2 && parts[2] != "" {
+		group = parts[2]
+	}
+	rule.gid, err = lookupRunAsGroup(group)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run_as %s: %s", definition, err.Error())
+	}
+
+	// supplementary groups default to all groups of the user
+	groups := []string{}
+	if len(parts) > 3 {
+		for _, name := range strings.Split(parts[3], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				groups = append(groups, name)
+			}
+		}
+	} else if ids, err := usr.GroupIds(); err == nil {
+		groups = ids
+	}
+	for _, name := range groups {
+		gid, err := lookupRunAsGroup(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid run_as %s: %s", definition, err.Error())
+		}
+		rule.groups = append(rule.groups, gid)
+	}
+
+	return rule, nil
+}
+
+func lookupRunAsUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+func lookupRunAsGroup(name string) (uint32, error) {
+	if gid, err := parseRunAsID(name); err == nil {
+		return gid, nil
+	}
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseRunAsID(group.Gid)
+}
+
+func parseRunAsID(id string) (uint32, error) {
+	num, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %s", id)
+	}
+
+	return uint32(num), nil
+}
+
+// matches returns true if the rule applies to this job
+func (rule *runAsRule) matches(received *request) bool {
+	if strings.HasPrefix(rule.match, "/") {
+		fields := strings.Fields(received.commandLine)
+
+		return len(fields) > 0 && strings.HasPrefix(fields[0], rule.match)
+	}
+
+	return received.queue == rule.match
+}
+
+// runAsRule returns the first matching run_as rule or nil
+func (config *config) runAsRule(received *request) *runAsRule {
+	for _, rule := range config.runAsRules {
+		if rule.matches(received) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// checkRunAsConfig resolves all run_as rules
+func checkRunAsConfig(config *config) error {
+	config.runAsRules = nil
+	for _, definition := range config.runAs {
+		rule, err := parseRunAsRule(definition)
+		if err != nil {
+			return err
+		}
+		config.runAsRules = append(config.runAsRules, rule)
+	}
+
+	if len(config.runAsRules) > 0 {
+		switch {
+		case runtime.GOOS == "windows":
+			log.Warnf("run_as is not supported on windows")
+		case os.Geteuid() != 0:
+			log.Warnf("run_as requires the worker to run as root, plugins will run as current user")
+		}
+	}
+
+	return nil
+}
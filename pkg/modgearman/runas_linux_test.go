@@ -0,0 +1,38 @@
+package modgearman
+
+import (
+	"os"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAsExecute(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("run_as requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("user nobody does not exist")
+	}
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.runAs = []string{"hostgroup_dmz:nobody:65534:"}
+	require.NoError(t, checkRunAsConfig(&cfg))
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/usr/bin/id -u", queue: "hostgroup_dmz", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, "65534", result.output)
+
+	result = readAndExecute(&request{typ: "service", commandLine: "/usr/bin/id -G", queue: "hostgroup_dmz", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, "65534", result.output)
+
+	// other queues run as worker user
+	result = readAndExecute(&request{typ: "service", commandLine: "/usr/bin/id -u", queue: "service", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, "0", result.output)
+}
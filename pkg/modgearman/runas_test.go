@@ -0,0 +1,53 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunAsRule(t *testing.T) {
+	rule, err := parseRunAsRule("/usr/lib/nagios/plugins/:0")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/lib/nagios/plugins/", rule.match)
+	assert.Equal(t, "root", rule.user)
+	assert.Equal(t, uint32(0), rule.uid)
+	assert.Equal(t, uint32(0), rule.gid)
+
+	rule, err = parseRunAsRule("hostgroup_dmz:root:123:0,456")
+	require.NoError(t, err)
+	assert.Equal(t, "hostgroup_dmz", rule.match)
+	assert.Equal(t, uint32(123), rule.gid)
+	assert.Equal(t, []uint32{0, 456}, rule.groups)
+
+	for _, definition := range []string{"", "root", ":root", "queue:", "queue:root:0:0:0", "queue:nonexisting_user_xyz", "queue:root:nonexisting_group_xyz"} {
+		_, err := parseRunAsRule(definition)
+		assert.Errorf(t, err, "invalid run_as: %s", definition)
+	}
+}
+
+func TestRunAsRuleMatch(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.runAs = []string{"/opt/dmz/:0:1", "hostgroup_dmz:0:2", "/opt/:0:3"}
+	require.NoError(t, checkRunAsConfig(&cfg))
+	require.Len(t, cfg.runAsRules, 3)
+
+	rule := cfg.runAsRule(&request{commandLine: "/opt/dmz/check_ping -H localhost", queue: "hostgroup_dmz"})
+	require.NotNil(t, rule)
+	assert.Equal(t, uint32(1), rule.gid)
+
+	rule = cfg.runAsRule(&request{commandLine: "/usr/bin/check_ping -H localhost", queue: "hostgroup_dmz"})
+	require.NotNil(t, rule)
+	assert.Equal(t, uint32(2), rule.gid)
+
+	rule = cfg.runAsRule(&request{commandLine: "/opt/check_ping", queue: "service"})
+	require.NotNil(t, rule)
+	assert.Equal(t, uint32(3), rule.gid)
+
+	assert.Nil(t, cfg.runAsRule(&request{commandLine: "/usr/bin/check_ping", queue: "service"}))
+
+	cfg.runAs = []string{"queue:nonexisting_user_xyz"}
+	require.Error(t, checkRunAsConfig(&cfg))
+}
@@ -0,0 +1,77 @@
+package modgearman
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// sandboxExecArg is used to re-execute the worker binary as sandbox helper for a plugin
+const sandboxExecArg = "__mod_gearman_sandbox_exec"
+
+// sandboxProfile defines how plugins matching the path prefix are isolated
+type sandboxProfile struct {
+	prefix    string
+	noNetwork bool
+}
+
+// parseSandboxProfile parses a sandbox definition like <path prefix>[:<option>,...]
+func parseSandboxProfile(definition string) (*sandboxProfile, error) {
+	prefix, options, _ := strings.Cut(definition, ":")
+	if !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("invalid sandbox %s, expected <path prefix>[:no_network]", definition)
+	}
+
+	profile := &sandboxProfile{prefix: prefix}
+	for _, option := range strings.Split(options, ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "":
+		case "no_network":
+			profile.noNetwork = true
+		default:
+			return nil, fmt.Errorf("unknown sandbox option %s in %s, supported options are: no_network", option, definition)
+		}
+	}
+
+	return profile, nil
+}
+
+// sandboxProfile returns the sandbox profile with the longest path prefix matching the command line or nil
+func (config *config) sandboxProfile(cmdString string) *sandboxProfile {
+	if len(config.sandboxProfiles) == 0 {
+		return nil
+	}
+	fields := strings.Fields(cmdString)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var match *sandboxProfile
+	for _, profile := range config.sandboxProfiles {
+		if !strings.HasPrefix(fields[0], profile.prefix) {
+			continue
+		}
+		if match == nil || len(profile.prefix) > len(match.prefix) {
+			match = profile
+		}
+	}
+
+	return match
+}
+
+// checkSandboxConfig parses all sandbox definitions
+func checkSandboxConfig(config *config) error {
+	config.sandboxProfiles = nil
+	for _, definition := range config.sandboxes {
+		profile, err := parseSandboxProfile(definition)
+		if err != nil {
+			return err
+		}
+		config.sandboxProfiles = append(config.sandboxProfiles, profile)
+	}
+	if len(config.sandboxProfiles) > 0 && runtime.GOOS != "linux" {
+		log.Warnf("sandbox is only supported on linux")
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package modgearman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sandboxNobodyID is used if the nobody user does not exist
+	sandboxNobodyID = 65534
+
+	// selfExe refers to the running worker binary regardless of its folder permissions
+	selfExe = "/proc/self/exe"
+)
+
+// the worker binary is re-executed inside the namespaces to prepare the sandbox before starting the plugin
+func init() {
+	if len(os.Args) > 2 && os.Args[1] == sandboxExecArg {
+		sandboxExec(os.Args[2], os.Args[3:])
+	}
+}
+
+// apply starts the command through the sandbox helper in new user, mount and pid namespaces
+func (profile *sandboxProfile) apply(cmd *exec.Cmd, rule *runAsRule) {
+	if profile == nil {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		cmd.Err = fmt.Errorf("cannot start sandbox: %s", err.Error())
+
+		return
+	}
+	cmd.Args = append([]string{exe, sandboxExecArg, cmd.Path}, cmd.Args...)
+	// the run_as user might not be allowed to access the folder of the worker binary
+	cmd.Path = selfExe
+
+	// map sandbox root to the worker user or the run_as user, host root is never mapped into the sandbox
+	uid, gid := os.Geteuid(), os.Getegid()
+	root := uid == 0
+	var groups []uint32
+	if root {
+		if rule == nil {
+			rule = sandboxNobody()
+		}
+		uid, gid, groups = int(rule.uid), int(rule.gid), rule.groups
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if profile.noNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	cmd.SysProcAttr.GidMappings = sandboxGidMappings(gid, groups)
+	// supplementary groups can only be set when running as root
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = root
+	credential := &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: !root}
+	for _, mapping := range cmd.SysProcAttr.GidMappings[1:] {
+		credential.Groups = append(credential.Groups, uint32(mapping.ContainerID))
+	}
+	cmd.SysProcAttr.Credential = credential
+}
+
+// sandboxNobody returns the unprivileged user which is used inside the sandbox instead of root
+func sandboxNobody() *runAsRule {
+	rule := &runAsRule{user: "nobody", uid: sandboxNobodyID, gid: sandboxNobodyID}
+	usr, err := lookupRunAsUser(rule.user)
+	if err != nil {
+		return rule
+	}
+	if uid, err := parseRunAsID(usr.Uid); err == nil {
+		rule.uid = uid
+	}
+	if gid, err := parseRunAsID(usr.Gid); err == nil {
+		rule.gid = gid
+	}
+
+	return rule
+}
+
+// sandboxGidMappings maps the primary group to sandbox root and keeps the ids of all supplementary groups
+func sandboxGidMappings(gid int, groups []uint32) []syscall.SysProcIDMap {
+	mappings := []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+	seen := map[uint32]bool{0: true, uint32(gid): true}
+	for _, group := range groups {
+		// mappings must not overlap, the host root group is never mapped
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+		mappings = append(mappings, syscall.SysProcIDMap{ContainerID: int(group), HostID: int(group), Size: 1})
+	}
+
+	return mappings
+}
+
+// sandboxExec prepares the mount namespace and replaces itself with the plugin, it never returns
+func sandboxExec(path string, args []string) {
+	// capabilities are per thread, so prepare and exec from the same thread
+	runtime.LockOSThread()
+
+	if err := setupSandboxMounts(); err != nil {
+		sandboxExit(err)
+	}
+	if err := dropCapabilities(); err != nil {
+		sandboxExit(err)
+	}
+
+	sandboxExit(syscall.Exec(path, args, os.Environ()))
+}
+
+func sandboxExit(err error) {
+	fmt.Fprintf(os.Stderr, "UNKNOWN: sandbox setup failed: %s\n", err.Error())
+	os.Exit(ExitCodeUnknown)
+}
+
+// setupSandboxMounts makes the root filesystem read-only and mounts a private /tmp
+func setupSandboxMounts() error {
+	// do not propagate any mount changes to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("cannot make mounts private: %s", err.Error())
+	}
+
+	err := unix.MountSetattr(unix.AT_FDCWD, "/", unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY})
+	if errors.Is(err, unix.ENOSYS) {
+		// kernel before 5.12, only the root mount itself will be read-only
+		err = unix.Mount("", "/", "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, "")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot make root filesystem read-only: %s", err.Error())
+	}
+
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("cannot mount private /tmp: %s", err.Error())
+	}
+
+	// show sandboxed processes only, not possible if /proc is partly hidden, ex. inside containers
+	_ = unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	return nil
+}
+
+// dropCapabilities removes all capabilities, so the plugin cannot revert the sandbox mounts
+func dropCapabilities() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("cannot set no_new_privs: %s", err.Error())
+	}
+
+	// drop the bounding set, so executing as sandbox root does not grant any capabilities
+	for capID := 0; capID < 64; capID++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capID), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot drop capability %d: %s", capID, err.Error())
+		}
+	}
+
+	// clear effective, permitted and inheritable set
+	data := [2]unix.CapUserData{}
+	if err := unix.Capset(&unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}, &data[0]); err != nil {
+		return fmt.Errorf("cannot drop capabilities: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package modgearman
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxExecute(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.sandboxes = []string{"/bin/"}
+	require.NoError(t, checkSandboxConfig(&cfg))
+
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'id -u; touch /tmp/sandbox_test && echo tmp ok; touch /sandbox_test'", timeout: 10}, &cfg)
+	if strings.Contains(result.output, "sandbox setup failed") || strings.Contains(result.output, "operation not permitted") {
+		t.Skipf("namespaces not available: %s", result.output)
+	}
+	assert.Equal(t, 1, result.returnCode)
+	assert.Contains(t, result.output, `0\ntmp ok\n`)
+	assert.Contains(t, result.output, "Read-only file system")
+	assert.NoFileExists(t, "/tmp/sandbox_test")
+	assert.NoFileExists(t, "/sandbox_test")
+
+	cfg.sandboxes = []string{"/bin/:no_network"}
+	require.NoError(t, checkSandboxConfig(&cfg))
+	result = readAndExecute(&request{typ: "service", commandLine: "/bin/cat /proc/net/dev", timeout: 10}, &cfg)
+	require.Equal(t, 0, result.returnCode)
+	assert.NotContains(t, result.output, "eth0")
+
+	// plugins outside the sandbox prefix are not affected
+	result = readAndExecute(&request{typ: "service", commandLine: "/usr/bin/id -u", timeout: 10}, &cfg)
+	assert.Equal(t, 0, result.returnCode)
+	assert.Equal(t, strconv.Itoa(os.Geteuid()), result.output)
+}
+
+func TestSandboxGidMappings(t *testing.T) {
+	mappings := sandboxGidMappings(1000, []uint32{1000, 0, 20, 30, 20})
+	require.Len(t, mappings, 3)
+	assert.Equal(t, syscall.SysProcIDMap{ContainerID: 0, HostID: 1000, Size: 1}, mappings[0])
+	assert.Equal(t, syscall.SysProcIDMap{ContainerID: 20, HostID: 20, Size: 1}, mappings[1])
+	assert.Equal(t, syscall.SysProcIDMap{ContainerID: 30, HostID: 30, Size: 1}, mappings[2])
+}
+
+func TestSandboxRootUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root")
+	}
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.sandboxes = []string{"/bin/"}
+	require.NoError(t, checkSandboxConfig(&cfg))
+
+	// root is mapped to nobody without run_as rule
+	nobody := sandboxNobody()
+	result := readAndExecute(&request{typ: "service", commandLine: "/bin/cat /proc/self/uid_map", timeout: 10}, &cfg)
+	if strings.Contains(result.output, "sandbox setup failed") || strings.Contains(result.output, "operation not permitted") {
+		t.Skipf("namespaces not available: %s", result.output)
+	}
+	require.Equal(t, 0, result.returnCode, result.output)
+	assert.Equal(t, []string{"0", strconv.FormatUint(uint64(nobody.uid), 10), "1"}, strings.Fields(result.output))
+
+	// run_as users keep their supplementary groups
+	cfg.runAsRules = []*runAsRule{{match: "/bin/", user: "nobody", uid: nobody.uid, gid: nobody.gid, groups: []uint32{nobody.gid, 4242}}}
+	result = readAndExecute(&request{typ: "service", commandLine: "/bin/sh -c 'id -G'", timeout: 10}, &cfg)
+	require.Equal(t, 0, result.returnCode, result.output)
+	assert.Equal(t, "0 4242", result.output)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package modgearman
+
+import (
+	"os/exec"
+)
+
+func (profile *sandboxProfile) apply(_ *exec.Cmd, _ *runAsRule) {
+	// namespaces are only supported on linux
+}
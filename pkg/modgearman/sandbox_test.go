@@ -0,0 +1,42 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSandboxProfile(t *testing.T) {
+	profile, err := parseSandboxProfile("/opt/plugins/")
+	require.NoError(t, err)
+	assert.Equal(t, &sandboxProfile{prefix: "/opt/plugins/"}, profile)
+
+	profile, err = parseSandboxProfile("/opt/plugins/:no_network")
+	require.NoError(t, err)
+	assert.Equal(t, &sandboxProfile{prefix: "/opt/plugins/", noNetwork: true}, profile)
+
+	for _, definition := range []string{"", "opt/plugins", ":no_network", "/opt:network"} {
+		_, err := parseSandboxProfile(definition)
+		assert.Errorf(t, err, "invalid sandbox: %s", definition)
+	}
+}
+
+func TestSandboxProfileMatch(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	assert.Nil(t, cfg.sandboxProfile("/opt/plugins/check_test"))
+
+	cfg.sandboxes = []string{"/opt/plugins/local/:no_network", "/opt/"}
+	require.NoError(t, checkSandboxConfig(&cfg))
+
+	assert.Equal(t, &sandboxProfile{prefix: "/opt/"}, cfg.sandboxProfile("/opt/plugins/check_test -w 1"))
+	assert.Equal(t, &sandboxProfile{prefix: "/opt/plugins/local/", noNetwork: true}, cfg.sandboxProfile("/opt/plugins/local/check_test"))
+	assert.Nil(t, cfg.sandboxProfile("/usr/bin/check_test"))
+
+	cmd := parseCommand("/opt/plugins/check_test -w 1", &cfg)
+	assert.Equal(t, &sandboxProfile{prefix: "/opt/"}, cmd.Sandbox)
+
+	cfg.sandboxes = []string{"/opt/:network"}
+	require.Error(t, checkSandboxConfig(&cfg))
+}
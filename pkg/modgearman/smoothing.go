@@ -0,0 +1,94 @@
+package modgearman
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// smoothingDelay returns how long the check should wait before it gets executed.
+//
+// Only host and service checks are delayed. Each check gets a stable offset within check_smoothing_window derived from its host
+// and service name. The offset is relative to the scheduled check time (next_check or
+// core_time), so checks which have been delayed already by the core or the queue are
+// started right away and a burst of checks scheduled for the same time is spread
+// across the window. Checks never wait beyond max-age.
+func smoothingDelay(received *request, config *config, now time.Time) time.Duration {
+	if config.checkSmoothingWindow <= 0 {
+		return 0
+	}
+	if received.typ != "host" && received.typ != "service" {
+		return 0
+	}
+
+	scheduled := received.nextCheck
+	if scheduled <= 0 || (received.coreTime > 0 && scheduled > received.coreTime) {
+		// next_check in the future means the core sent the check early, use the submit time then
+		scheduled = received.coreTime
+	}
+	if scheduled <= 0 {
+		return 0
+	}
+
+	window := time.Duration(config.checkSmoothingWindow) * time.Second
+	target := floatToTime(scheduled).Add(smoothingOffset(received, window))
+	delay := target.Sub(now)
+
+	// checks scheduled in the future because of clock skew wait at most one window
+	delay = min(delay, window)
+
+	// keep 1 second safety margin to max-age
+	if config.maxAge > 0 {
+		deadline := floatToTime(received.coreTime).Add(time.Duration(config.maxAge-1) * time.Second)
+		delay = min(delay, deadline.Sub(now))
+	}
+
+	return max(delay, 0)
+}
+
+// smoothingOffset returns the stable offset of this check within the window
+func smoothingOffset(received *request, window time.Duration) time.Duration {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(received.hostName + ";" + received.serviceDescription))
+
+	return time.Duration(hash.Sum64() % uint64(window))
+}
+
+// waitForSmoothing delays the check according to check_smoothing_window. Delayed checks
+// keep their job slot and can be canceled. Returns false if the check has been canceled.
+func waitForSmoothing(received *request, config *config) bool {
+	delay := smoothingDelay(received, config, time.Now())
+	if delay <= 0 {
+		return true
+	}
+
+	log.Tracef("delaying check %s - %s by %s", received.hostName, received.serviceDescription, delay)
+	smoothingWaitingCount.Inc()
+	defer smoothingWaitingCount.Dec()
+	smoothingDelayCounter.Add(delay.Seconds())
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	canceled := make(chan struct{})
+	var once sync.Once
+	received.setCancel(func() {
+		once.Do(func() { close(canceled) })
+	})
+	received.setDelayed(true)
+	defer func() {
+		received.setDelayed(false)
+		received.setCancel(nil)
+	}()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-canceled:
+		return false
+	}
+}
+
+func floatToTime(timestamp float64) time.Time {
+	return time.Unix(0, int64(timestamp*float64(time.Second)))
+}
@@ -0,0 +1,93 @@
+package modgearman
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmoothingDelay(t *testing.T) {
+	cfg := config{checkSmoothingWindow: 30}
+	now := time.Now()
+	nowFloat := float64(now.UnixNano()) / float64(time.Second)
+
+	received := &request{typ: "service", hostName: "host", serviceDescription: "svc", coreTime: nowFloat, nextCheck: nowFloat}
+	offset := smoothingOffset(received, 30*time.Second)
+	assert.Less(t, offset, 30*time.Second)
+	assert.Equal(t, offset, smoothingOffset(received, 30*time.Second), "offset is stable")
+	assert.InDelta(t, offset.Seconds(), smoothingDelay(received, &cfg, now).Seconds(), 0.001)
+
+	// checks of a burst get spread across the window
+	offsets := map[time.Duration]bool{}
+	for _, svc := range []string{"a", "b", "c", "d", "e"} {
+		offsets[smoothingOffset(&request{hostName: "host", serviceDescription: svc}, 30*time.Second)] = true
+	}
+	assert.Len(t, offsets, 5)
+
+	// late checks start immediately
+	late := &request{typ: "service", hostName: "host", serviceDescription: "svc", coreTime: nowFloat - 60, nextCheck: nowFloat - 60}
+	assert.Equal(t, time.Duration(0), smoothingDelay(late, &cfg, now))
+
+	// notifications and eventhandlers are not delayed
+	for _, typ := range []string{"notification", "eventhandler"} {
+		other := &request{typ: typ, hostName: "host", serviceDescription: "svc", coreTime: nowFloat, nextCheck: nowFloat}
+		assert.Equalf(t, time.Duration(0), smoothingDelay(other, &cfg, now), "type %s", typ)
+	}
+
+	// clock skew waits at most one window
+	future := &request{typ: "host", hostName: "host", coreTime: nowFloat + 300, nextCheck: nowFloat + 300}
+	assert.Equal(t, 30*time.Second, smoothingDelay(future, &cfg, now))
+
+	// max-age limits the delay
+	cfg.maxAge = 5
+	assert.InDelta(t, min(offset, 4*time.Second).Seconds(), smoothingDelay(received, &cfg, now).Seconds(), 0.001)
+
+	// disabled
+	cfg.checkSmoothingWindow = 0
+	assert.Equal(t, time.Duration(0), smoothingDelay(received, &cfg, now))
+
+	// unknown schedule
+	cfg.checkSmoothingWindow = 30
+	assert.Equal(t, time.Duration(0), smoothingDelay(&request{typ: "host", hostName: "host"}, &cfg, now))
+}
+
+func TestSmoothingWait(t *testing.T) {
+	disableLogging()
+	defer setLogLevel(0)
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.checkSmoothingWindow = 60
+	cfg.cancelReturn = 2
+	nowFloat := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	// find a check with a long delay
+	received := &request{typ: "service", hostName: "host", commandLine: "/bin/true", timeout: 10, coreTime: nowFloat, nextCheck: nowFloat}
+	for i := 0; smoothingDelay(received, &cfg, time.Now()) < 10*time.Second; i++ {
+		received.serviceDescription = fmt.Sprintf("svc%d", i)
+	}
+
+	wrk := &worker{id: "w1", config: &cfg}
+	wrk.addJob(received)
+	wrk.activeJobs = 1
+
+	done := make(chan *answer, 1)
+	go func() {
+		done <- readAndExecute(received, &cfg)
+	}()
+	require.Eventually(t, received.isDelayed, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, wrk.isBusy(), "delayed jobs occupy their job slot")
+	assert.True(t, received.Cancel(true))
+
+	select {
+	case result := <-done:
+		assert.Equal(t, 2, result.returnCode)
+		assert.Contains(t, result.output, "Canceled")
+		assert.False(t, received.isDelayed())
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed check has not been canceled")
+	}
+}
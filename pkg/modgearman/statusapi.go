@@ -0,0 +1,514 @@
+package modgearman
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	time "time"
+)
+
+var (
+	// statusListener is the http listener of the status api, nil if disabled or shared with prometheus
+	statusListener net.Listener
+
+	// currentMainWorker is used by the status api to inspect the running worker
+	currentMainWorker atomic.Pointer[mainWorker]
+)
+
+type statusResponse struct {
+	Identifier        string          `json:"identifier"`
+	Version           string          `json:"version"`
+	Pid               int             `json:"pid"`
+	Tasks             int             `json:"tasks"`
+	ActiveWorkers     int             `json:"active_workers"`
+	BallooningWorkers int             `json:"ballooning_workers"`
+	IdleSince         time.Time       `json:"idle_since"`
+	Workers           []*statusWorker `json:"workers"`
+	Servers           []*statusServer `json:"servers"`
+	Load              *statusLoad     `json:"load"`
+	Memory            *statusMemory   `json:"memory"`
+	EPN               *statusEPN      `json:"epn"`
+	Drain             *statusDrain    `json:"drain"`
+	Config            map[string]any  `json:"config"`
+}
+
+type statusWorker struct {
+	ID         string       `json:"id"`
+	Pool       string       `json:"pool"`
+	ActiveJobs int          `json:"active_jobs"`
+	Jobs       []*statusJob `json:"jobs"`
+}
+
+type statusJob struct {
+	Handle             string  `json:"handle"`
+	Type               string  `json:"type"`
+	HostName           string  `json:"host_name"`
+	ServiceDescription string  `json:"service_description,omitempty"`
+	CommandLine        string  `json:"command_line"`
+	Age                float64 `json:"age"`
+	Timeout            int     `json:"timeout"`
+	Ballooning         bool    `json:"ballooning"`
+	Delayed            bool    `json:"delayed"`
+}
+
+type cancelResponse struct {
+	Canceled      []*statusJob `json:"canceled"`
+	NotCancelable []*statusJob `json:"not_cancelable"`
+}
+
+type statusDrain struct {
+	Draining bool           `json:"draining"`
+	Since    *time.Time     `json:"since,omitempty"`
+	Exit     bool           `json:"exit"`
+	Finished bool           `json:"finished"`
+	Progress *drainProgress `json:"progress,omitempty"`
+}
+
+type statusServer struct {
+	Address string `json:"address"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+type statusLoad struct {
+	Load1   float64 `json:"load1"`
+	Load5   float64 `json:"load5"`
+	Load15  float64 `json:"load15"`
+	Limit1  float64 `json:"limit1"`
+	Limit5  float64 `json:"limit5"`
+	Limit15 float64 `json:"limit15"`
+	OK      bool    `json:"ok"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+type statusMemory struct {
+	Total  uint64 `json:"total"`
+	Free   uint64 `json:"free"`
+	Limit  uint64 `json:"limit"`
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type statusEPN struct {
+	Enabled  bool       `json:"enabled"`
+	Running  bool       `json:"running"`
+	Pid      int        `json:"pid,omitempty"`
+	Socket   string     `json:"socket,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Stopping int        `json:"stopping"`
+}
+
+// startStatusServer starts the http status api listener unless it shares the prometheus listener
+func startStatusServer(config *config) (listener net.Listener) {
+	if config.statusServer == "" || config.statusServer == config.prometheusServer {
+		return nil
+	}
+
+	listen, err := net.Listen("tcp", config.statusServer)
+	if err != nil {
+		log.Fatalf("starting status server failed: %s", err)
+	}
+	go func() {
+		defer logPanicExit()
+
+		mux := http.NewServeMux()
+		registerStatusHandlers(mux, config)
+		logDebug(http.Serve(listen, mux))
+		log.Debugf("status listener %s stopped", config.statusServer)
+	}()
+	log.Debugf("serving status api at %s/status", config.statusServer)
+
+	return listen
+}
+
+func stopStatusServer(listener net.Listener) {
+	if listener == nil {
+		return
+	}
+
+	log.Debugf("stopping status listener")
+	listener.Close()
+}
+
+func registerStatusHandlers(mux *http.ServeMux, config *config) {
+	mux.HandleFunc("GET /status", statusHandler)
+	mux.HandleFunc("GET /jobs", jobsHandler)
+	if !config.statusAdmin {
+		return
+	}
+	mux.HandleFunc("POST /jobs/cancel", cancelJobsHandler)
+	mux.HandleFunc("POST /drain", drainHandler)
+	mux.HandleFunc("POST /undrain", undrainHandler)
+}
+
+func statusHandler(w http.ResponseWriter, _ *http.Request) {
+	mainworker := currentMainWorker.Load()
+	if mainworker == nil {
+		http.Error(w, "worker not running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	writeStatusJSON(w, http.StatusOK, mainworker.status())
+}
+
+// jobsHandler lists all running jobs
+func jobsHandler(w http.ResponseWriter, _ *http.Request) {
+	mainworker := currentMainWorker.Load()
+	if mainworker == nil {
+		http.Error(w, "worker not running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	jobs := []*statusJob{}
+	for _, wrk := range mainworker.statusWorkers() {
+		jobs = append(jobs, wrk.Jobs...)
+	}
+	writeStatusJSON(w, http.StatusOK, jobs)
+}
+
+// cancelJobsHandler cancels running jobs by handle or by host_name and optional service_description
+func cancelJobsHandler(w http.ResponseWriter, r *http.Request) {
+	mainworker := currentMainWorker.Load()
+	if mainworker == nil {
+		http.Error(w, "worker not running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	handle := r.FormValue("handle")
+	hostName := r.FormValue("host_name")
+	serviceDescription := r.FormValue("service_description")
+	if handle == "" && hostName == "" {
+		http.Error(w, "either handle or host_name is required", http.StatusBadRequest)
+
+		return
+	}
+
+	matched := mainworker.cancelJobs(func(req *request) bool {
+		switch {
+		case handle != "" && req.handle != handle:
+			return false
+		case hostName != "" && req.hostName != hostName:
+			return false
+		case serviceDescription != "" && req.serviceDescription != serviceDescription:
+			return false
+		}
+
+		return true
+	})
+
+	now := time.Now()
+	res := &cancelResponse{Canceled: []*statusJob{}, NotCancelable: []*statusJob{}}
+	for _, req := range matched {
+		if req.isAdminCanceled() {
+			res.Canceled = append(res.Canceled, newStatusJob(req, now))
+		} else {
+			res.NotCancelable = append(res.NotCancelable, newStatusJob(req, now))
+		}
+	}
+
+	code := http.StatusOK
+	if len(matched) == 0 {
+		code = http.StatusNotFound
+	}
+	writeStatusJSON(w, code, res)
+}
+
+// drainHandler puts the worker into drain mode, the optional exit parameter
+// overrides the drain_exit setting
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	mainworker := currentMainWorker.Load()
+	if mainworker == nil {
+		http.Error(w, "worker not running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	exit := mainworker.cfg.drainExit
+	if value := r.FormValue("exit"); value != "" {
+		exit = getBool(value)
+	}
+	mainworker.startDrain(exit)
+	writeStatusJSON(w, http.StatusOK, mainworker.statusDrain())
+}
+
+// undrainHandler leaves the drain mode
+func undrainHandler(w http.ResponseWriter, _ *http.Request) {
+	mainworker := currentMainWorker.Load()
+	if mainworker == nil {
+		http.Error(w, "worker not running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	code := http.StatusOK
+	if !mainworker.stopDrain() {
+		code = http.StatusConflict
+	}
+	writeStatusJSON(w, code, mainworker.statusDrain())
+}
+
+func writeStatusJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	logDebug(encoder.Encode(data))
+}
+
+// cancelJobs cancels all running jobs matching the filter and returns all matched jobs
+func (w *mainWorker) cancelJobs(match func(*request) bool) (matched []*request) {
+	for _, wrk := range w.listWorkers() {
+		matched = append(matched, wrk.cancelJobs(match)...)
+	}
+
+	return matched
+}
+
+// status returns the current state of the worker
+func (w *mainWorker) status() *statusResponse {
+	cfg := w.cfg
+	loadOK, loadReason := w.checkLoads()
+	memOK, memReason := w.checkMemory()
+	min1, min5, min15 := w.loadAvg()
+	memTotal, memFree := w.memInfo()
+
+	status := &statusResponse{
+		Identifier:        cfg.identifier,
+		Version:           VERSION,
+		Pid:               os.Getpid(),
+		Tasks:             int(w.tasks.Load()),
+		ActiveWorkers:     int(w.activeWorkers.Load()),
+		BallooningWorkers: w.numBallooningWorker(),
+		IdleSince:         w.getIdleSince(),
+		Workers:           w.statusWorkers(),
+		Servers:           w.statusServers(),
+		Load: &statusLoad{
+			Load1:   min1,
+			Load5:   min5,
+			Load15:  min15,
+			Limit1:  cfg.loadLimit1,
+			Limit5:  cfg.loadLimit5,
+			Limit15: cfg.loadLimit15,
+			OK:      loadOK,
+			Reason:  loadReason,
+		},
+		Memory: &statusMemory{
+			Total:  memTotal * 1024,
+			Free:   memFree * 1024,
+			Limit:  cfg.memLimit,
+			OK:     memOK,
+			Reason: memReason,
+		},
+		EPN:    statusEmbeddedPerl(cfg),
+		Drain:  w.statusDrain(),
+		Config: cfg.effectiveConfig(),
+	}
+
+	return status
+}
+
+func (w *mainWorker) statusDrain() *statusDrain {
+	w.drain.lock.Lock()
+	status := &statusDrain{
+		Draining: w.drain.active,
+		Exit:     w.drain.exit,
+		Finished: w.drain.finished,
+	}
+	if w.drain.active {
+		since := w.drain.since
+		status.Since = &since
+	}
+	w.drain.lock.Unlock()
+
+	if status.Draining {
+		status.Progress = w.drainProgress()
+	}
+
+	return status
+}
+
+func (w *mainWorker) listWorkers() []*worker {
+	w.workerMapLock.RLock()
+	defer w.workerMapLock.RUnlock()
+
+	workers := make([]*worker, 0, len(w.workerMap))
+	for _, wrk := range w.workerMap {
+		workers = append(workers, wrk)
+	}
+
+	return workers
+}
+
+func (w *mainWorker) statusWorkers() []*statusWorker {
+	workers := w.listWorkers()
+	now := time.Now()
+	list := make([]*statusWorker, 0, len(workers))
+	for _, wrk := range workers {
+		entry := &statusWorker{
+			ID:         wrk.id,
+			Pool:       wrk.poolName(),
+			ActiveJobs: wrk.numActiveJobs(),
+			Jobs:       []*statusJob{},
+		}
+		wrk.lock.RLock()
+		for _, job := range wrk.jobs {
+			entry.Jobs = append(entry.Jobs, newStatusJob(job, now))
+		}
+		wrk.lock.RUnlock()
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+func newStatusJob(job *request, now time.Time) *statusJob {
+	return &statusJob{
+		Handle:             job.handle,
+		Type:               job.typ,
+		HostName:           job.hostName,
+		ServiceDescription: job.serviceDescription,
+		CommandLine:        job.commandLine,
+		Age:                now.Sub(job.receivedAt).Seconds(),
+		Timeout:            job.timeout,
+		Ballooning:         job.ballooning,
+		Delayed:            job.isDelayed(),
+	}
+}
+
+func (w *mainWorker) statusServers() []*statusServer {
+	w.workerMapLock.RLock()
+	defer w.workerMapLock.RUnlock()
+
+	list := make([]*statusServer, 0, len(w.cfg.server))
+	for _, address := range w.cfg.server {
+		status := w.serverStatus[address]
+		list = append(list, &statusServer{
+			Address: address,
+			OK:      status == "",
+			Error:   status,
+		})
+	}
+
+	return list
+}
+
+func statusEmbeddedPerl(config *config) *statusEPN {
+	status := &statusEPN{
+		Enabled: config.enableEmbeddedPerl,
+		Started: ePNStarted,
+	}
+	daemon := ePNServer
+	if daemon != nil {
+		status.Running = true
+		status.Pid = daemon.Pid
+		status.Socket = daemon.Socket
+	}
+	ePNServerStopQueue.Range(func(key, _ any) bool {
+		if daemon == nil || key != daemon.Pid {
+			status.Stopping++
+		}
+
+		return true
+	})
+
+	return status
+}
+
+// effectiveConfig returns all config attributes by name, secrets and credentials in urls are masked
+func (config *config) effectiveConfig() map[string]any {
+	attributes := map[string]any{}
+	val := reflect.ValueOf(config).Elem()
+	for i := range val.NumField() {
+		name := val.Type().Field(i).Name
+		field := val.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			attributes[name] = redactURL(field.String())
+			if field.String() != "" && isSecretName(name) {
+				attributes[name] = "***"
+			}
+		case reflect.Bool:
+			attributes[name] = field.Bool()
+		case reflect.Int, reflect.Int64:
+			attributes[name] = field.Int()
+		case reflect.Uint64:
+			attributes[name] = field.Uint()
+		case reflect.Float64:
+			attributes[name] = field.Float()
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			list := make([]string, 0, field.Len())
+			for j := range field.Len() {
+				list = append(list, redactURL(field.Index(j).String()))
+			}
+			attributes[name] = list
+		default:
+		}
+	}
+
+	return attributes
+}
+
+// isSecretName returns true if the config attribute or url parameter contains a secret
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "key", "p":
+		return true
+	}
+	for _, secret := range []string{"password", "passwd", "token", "secret"} {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactURL removes the userinfo and masks secret query parameters of urls contained in a config value
+func redactURL(value string) string {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return value
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return scheme + "://***"
+	}
+	parsed.User = nil
+	if parsed.RawQuery != "" {
+		params := strings.Split(parsed.RawQuery, "&")
+		for i, param := range params {
+			key, _, _ := strings.Cut(param, "=")
+			if isSecretName(key) {
+				params[i] = key + "=***"
+			}
+		}
+		parsed.RawQuery = strings.Join(params, "&")
+	}
+
+	return parsed.String()
+}
+
+// redactURLError masks the url of http client errors, they contain the full url including secret query parameters
+func redactURLError(err error, rawURL string) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return &url.Error{Op: urlErr.Op, URL: redactURL(rawURL), Err: urlErr.Err}
+	}
+
+	return err
+}
@@ -1,14 +1,36 @@
 package modgearman
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	libworker "github.com/appscode/g2/worker"
 )
 
+// processStarted is used to calculate the uptime
+var processStarted = time.Now()
+
+// statusSummary is returned by the status worker for json requests
+type statusSummary struct {
+	Identifier  string           `json:"identifier"`
+	Version     string           `json:"version"`
+	Uptime      int64            `json:"uptime"`
+	Workers     int              `json:"workers"`
+	MinWorker   int              `json:"min_worker"`
+	MaxWorker   int              `json:"max_worker"`
+	Busy        int              `json:"busy"`
+	Idle        int              `json:"idle"`
+	Ballooning  int              `json:"ballooning"`
+	Tasks       int              `json:"tasks"`
+	TasksByExec map[string]int64 `json:"tasks_by_exec"`
+	Servers     []*statusServer  `json:"servers"`
+}
+
 // creates a new status worker and returns a pointer to it
 func newStatusWorker(configuration *config, mainWorker *mainWorker) *worker {
-	return newWorker("status", configuration, mainWorker)
+	return newWorker("status", nil, configuration, mainWorker)
 }
 
 func (worker *worker) returnStatus(job libworker.Job) (result []byte, err error) {
@@ -22,17 +44,68 @@ func (worker *worker) returnStatus(job libworker.Job) (result []byte, err error)
 	received := string(job.Data())
 	log.Tracef("job data: %s", received)
 
+	return worker.statusResponse(received)
+}
+
+// statusResponse returns the status for the requested payload, supported payloads are
+// json, jobs and config. Everything else returns the plain text status.
+func (worker *worker) statusResponse(payload string) (result []byte, err error) {
+	switch statusRequestType(payload) {
+	case "json":
+		return json.Marshal(worker.mainWorker.statusSummary())
+	case "jobs":
+		jobs := []*statusJob{}
+		for _, wrk := range worker.mainWorker.statusWorkers() {
+			jobs = append(jobs, wrk.Jobs...)
+		}
+
+		return json.Marshal(jobs)
+	case "config":
+		return json.Marshal(worker.mainWorker.cfg.effectiveConfig())
+	}
+
 	result = []byte(fmt.Sprintf(
 		"%s has %d worker and is working on %d jobs. Version: %s|worker=%d;;;%d;%d jobs=%dc",
 		worker.config.identifier,
 		len(worker.mainWorker.workerMap),
-		worker.mainWorker.activeWorkers,
+		worker.mainWorker.activeWorkers.Load(),
 		VERSION,
 		len(worker.mainWorker.workerMap),
 		worker.config.minWorker,
 		worker.config.maxWorker,
-		worker.mainWorker.tasks,
+		worker.mainWorker.tasks.Load(),
 	))
 
-	return
+	return result, nil
+}
+
+// statusRequestType returns the normalized status type of a status worker request
+func statusRequestType(payload string) string {
+	return strings.ToLower(strings.TrimSpace(payload))
+}
+
+func (w *mainWorker) statusSummary() *statusSummary {
+	w.workerMapLock.RLock()
+	numWorker := len(w.workerMap)
+	w.workerMapLock.RUnlock()
+
+	tasksByExec := map[string]int64{}
+	for exec, count := range promCounterVecByLabel(taskCounter, "exec") {
+		tasksByExec[exec] = int64(count)
+	}
+
+	return &statusSummary{
+		Identifier:  w.cfg.identifier,
+		Version:     VERSION,
+		Uptime:      int64(time.Since(processStarted).Seconds()),
+		Workers:     numWorker,
+		MinWorker:   w.cfg.minWorker,
+		MaxWorker:   w.cfg.maxWorker,
+		Busy:        int(w.activeWorkers.Load()),
+		Idle:        max(numWorker-int(w.activeWorkers.Load()), 0),
+		Ballooning:  w.numBallooningWorker(),
+		Tasks:       int(w.tasks.Load()),
+		TasksByExec: tasksByExec,
+		Servers:     w.statusServers(),
+	}
 }
@@ -0,0 +1,115 @@
+package modgearman
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusWorkerResponse(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.server = []string{"localhost:4730", "localhost:4731"}
+	cfg.key = "secret"
+
+	mainworker := &mainWorker{
+		cfg:           &cfg,
+		workerMap:     make(map[string]*worker),
+		workerMapLock: new(sync.RWMutex),
+		serverStatus:  map[string]string{"localhost:4731": "connection refused"},
+	}
+	mainworker.tasks.Store(3)
+	mainworker.activeWorkers.Store(1)
+	mainworker.curBallooningWorker.Store(1)
+	wrk := &worker{id: "w1", config: &cfg, mainWorker: mainworker}
+	wrk.addJob(&request{typ: "host", hostName: "testhost", commandLine: "/bin/true", handle: "H:1", receivedAt: time.Now()})
+	mainworker.workerMap[wrk.id] = wrk
+	mainworker.workerMap["w2"] = &worker{id: "w2", config: &cfg, mainWorker: mainworker}
+	status := &worker{what: "status", config: &cfg, mainWorker: mainworker}
+
+	taskCounter.WithLabelValues("host", "exec").Inc()
+
+	res, err := status.statusResponse("json\n")
+	require.NoError(t, err)
+	summary := statusSummary{}
+	require.NoError(t, json.Unmarshal(res, &summary))
+	assert.Equal(t, "testworker", summary.Identifier)
+	assert.Equal(t, VERSION, summary.Version)
+	assert.Equal(t, 2, summary.Workers)
+	assert.Equal(t, 1, summary.Busy)
+	assert.Equal(t, 1, summary.Idle)
+	assert.Equal(t, 1, summary.Ballooning)
+	assert.Equal(t, 3, summary.Tasks)
+	assert.GreaterOrEqual(t, summary.TasksByExec["exec"], int64(1))
+	require.Len(t, summary.Servers, 2)
+	assert.Equal(t, "connection refused", summary.Servers[1].Error)
+
+	res, err = status.statusResponse("jobs")
+	require.NoError(t, err)
+	jobs := []*statusJob{}
+	require.NoError(t, json.Unmarshal(res, &jobs))
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "H:1", jobs[0].Handle)
+	assert.Equal(t, "testhost", jobs[0].HostName)
+
+	res, err = status.statusResponse("config")
+	require.NoError(t, err)
+	conf := map[string]any{}
+	require.NoError(t, json.Unmarshal(res, &conf))
+	assert.Equal(t, "testworker", conf["identifier"])
+	assert.Equal(t, "***", conf["key"])
+
+	res, err = status.statusResponse("")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "testworker has 2 worker and is working on 1 jobs."), string(res))
+}
+
+func TestCheckGearmanStatusSummary(t *testing.T) {
+	assert.Nil(t, parseStatusSummary("testworker has 2 worker"))
+	assert.Nil(t, parseStatusSummary("{}"))
+
+	summary := parseStatusSummary(`{"identifier":"testworker","version":"1.0","uptime":3700,"workers":2,"min_worker":1,"max_worker":20,` +
+		`"busy":1,"idle":1,"ballooning":0,"tasks":5,"tasks_by_exec":{"shell":3,"exec":2},"servers":[{"address":"localhost:4730","ok":true}]}`)
+	require.NotNil(t, summary)
+	rc, output := formatStatusSummary(summary)
+	assert.Equal(t, stateOk, rc)
+	assert.Equal(t, "OK - testworker has 2 worker (busy: 1, idle: 1, ballooning: 0) and is up since 1h1m40s. Version: 1.0"+
+		"|worker=2;;;1;20 busy=1 idle=1 ballooning=0 jobs=5c 'jobs_exec'=2c 'jobs_shell'=3c", output)
+
+	summary.Servers = append(summary.Servers, &statusServer{Address: "localhost:4731", Error: "connection refused"})
+	rc, output = formatStatusSummary(summary)
+	assert.Equal(t, stateWarning, rc)
+	assert.True(t, strings.HasPrefix(output, "WARNING - "))
+	assert.True(t, strings.HasSuffix(output, "\nfailed server: localhost:4731: connection refused"))
+}
+
+func TestCheckGearmanStatusResponse(t *testing.T) {
+	config := `{"identifier":"testworker","key":"***","server":["localhost:4730"]}`
+	rc, output, ok := formatStatusResponse("config", config)
+	assert.True(t, ok)
+	assert.Equal(t, stateOk, rc)
+	assert.Equal(t, config, output)
+
+	rc, output, ok = formatStatusResponse(" JOBS ", "[]")
+	assert.True(t, ok)
+	assert.Equal(t, stateOk, rc)
+	assert.Equal(t, "[]", output)
+
+	rc, output, ok = formatStatusResponse("json", `{"identifier":"testworker","version":"1.0","workers":1}`)
+	assert.True(t, ok)
+	assert.Equal(t, stateOk, rc)
+	assert.True(t, strings.HasPrefix(output, "check_gearman OK - testworker has 1 worker"), output)
+
+	rc, _, ok = formatStatusResponse("json", "{}")
+	assert.True(t, ok)
+	assert.Equal(t, stateUnknown, rc)
+
+	_, _, ok = formatStatusResponse("", config)
+	assert.False(t, ok, "plain text status request")
+}
@@ -0,0 +1,319 @@
+package modgearman
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// tracerName sets the instrumentation scope of all job spans
+	tracerName = "github.com/consol-monitoring/mod-gearman-worker-go"
+
+	// traceShutdownTimeout sets how long pending spans may take to be exported on shutdown
+	traceShutdownTimeout = 5 * time.Second
+)
+
+// span attributes added to job spans
+const (
+	traceAttrHandle             = attribute.Key("modgearman.job.handle")
+	traceAttrType               = attribute.Key("modgearman.job.type")
+	traceAttrHostName           = attribute.Key("modgearman.host_name")
+	traceAttrServiceDescription = attribute.Key("modgearman.service_description")
+	traceAttrCommand            = attribute.Key("modgearman.command")
+	traceAttrExecType           = attribute.Key("modgearman.exec_type")
+	traceAttrReturnCode         = attribute.Key("modgearman.return_code")
+	traceAttrTimedOut           = attribute.Key("modgearman.timed_out")
+	traceAttrRetries            = attribute.Key("modgearman.retries")
+)
+
+var (
+	// currentTracing is replaced on reload while jobs are still creating spans, nil if tracing is disabled
+	currentTracing  atomic.Pointer[tracingState]
+	noopTracer      = noop.NewTracerProvider().Tracer(tracerName)
+	tracePropagator = propagation.TraceContext{}
+)
+
+// tracingState contains the tracer provider and its tracer
+type tracingState struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// getTracer returns the current tracer or a noop tracer if tracing is disabled
+func getTracer() trace.Tracer {
+	if state := currentTracing.Load(); state != nil {
+		return state.tracer
+	}
+
+	return noopTracer
+}
+
+// parseTraceExporter returns scheme and target of the trace exporter definition
+// supported formats are:
+//   - http://host:port or https://... (OTLP over http, path defaults to /v1/traces)
+//   - file:///path/traces.jsonl (append OTLP json lines to file)
+func parseTraceExporter(definition string) (scheme, target string, err error) {
+	scheme, target, ok := strings.Cut(definition, "://")
+	if !ok || target == "" {
+		return "", "", fmt.Errorf("invalid trace_exporter %s, expected <scheme>://<target>", redactURL(definition))
+	}
+
+	scheme = strings.ToLower(scheme)
+	switch scheme {
+	case "http", "https", "file":
+		return scheme, target, nil
+	default:
+		return "", "", fmt.Errorf("unsupported trace_exporter scheme %s in %s", scheme, redactURL(definition))
+	}
+}
+
+func newTraceExporter(definition string) (sdktrace.SpanExporter, error) {
+	scheme, target, err := parseTraceExporter(definition)
+	if err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "file":
+		return otlptrace.New(context.Background(), &traceFileClient{path: target})
+	default:
+		return otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(definition))
+	}
+}
+
+func initializeTracing(config *config) {
+	if config.traceExporter == "" {
+		return
+	}
+
+	exporter, err := newTraceExporter(config.traceExporter)
+	if err != nil {
+		log.Errorf("tracing disabled: %s", err.Error())
+
+		return
+	}
+
+	serviceName := config.binary
+	if serviceName == "" {
+		serviceName = "mod_gearman_worker"
+	}
+	log.Debugf("exporting traces to: %s", redactURL(config.traceExporter))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.traceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(VERSION),
+			semconv.ServiceInstanceID(config.identifier),
+		)),
+	)
+	currentTracing.Store(&tracingState{provider: provider, tracer: provider.Tracer(tracerName)})
+}
+
+// terminateTracing disables tracing and flushes all pending spans
+func terminateTracing() {
+	state := currentTracing.Swap(nil)
+	if state == nil {
+		return
+	}
+
+	log.Debugf("Terminating tracing")
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	if err := state.provider.Shutdown(ctx); err != nil {
+		log.Warnf("failed to flush traces: %s", err.Error())
+	}
+}
+
+// startJobSpan starts the root span of a job. Since the parent trace context is part of the
+// encrypted job data, the root span and the decrypt span are created after decrypting.
+func startJobSpan(handle string, received *request, start time.Time, decryptErr error) trace.Span {
+	parent := context.Background()
+	attributes := []attribute.KeyValue{traceAttrHandle.String(handle)}
+	if received != nil {
+		parent = tracePropagator.Extract(parent, propagation.MapCarrier{
+			"traceparent": received.traceParent,
+			"tracestate":  received.traceState,
+		})
+		attributes = append(attributes, requestTraceAttributes(received)...)
+	}
+
+	tracer := getTracer()
+	ctx, span := tracer.Start(parent, "doWork",
+		trace.WithTimestamp(start),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...),
+	)
+	_, decryptSpan := tracer.Start(ctx, "decryptJobData", trace.WithTimestamp(start))
+	setSpanError(decryptSpan, decryptErr)
+	decryptSpan.End()
+	setSpanError(span, decryptErr)
+
+	if received != nil {
+		received.traceCtx = ctx
+	}
+
+	return span
+}
+
+// startRequestSpan starts a child span of the current job span. The new span
+// will be the current job span until the returned end function is called.
+func startRequestSpan(received *request, name string, attributes ...attribute.KeyValue) (span trace.Span, end func()) {
+	parent := traceContext(received.traceCtx)
+	ctx, span := getTracer().Start(parent, name, trace.WithAttributes(attributes...))
+	received.traceCtx = ctx
+
+	return span, func() {
+		span.End()
+		received.traceCtx = parent
+	}
+}
+
+// startExecSpan starts the span for executing the command and passes
+// the trace context to the plugin environment
+func startExecSpan(received *request, com *command, name string) (span trace.Span, end func()) {
+	span, end = startRequestSpan(received, name, traceAttrCommand.String(getCommandQualifier(com)))
+	injectTraceEnv(received.traceCtx, com)
+
+	return span, end
+}
+
+// startResultSpan starts a child span of the job which created this result
+func startResultSpan(result *answer, name string) trace.Span {
+	_, span := getTracer().Start(traceContext(result.traceCtx), name, trace.WithAttributes(
+		traceAttrHostName.String(result.hostName),
+		traceAttrServiceDescription.String(result.serviceDescription),
+	))
+
+	return span
+}
+
+// injectTraceEnv sets the TRACEPARENT and TRACESTATE environment variables for the plugin
+func injectTraceEnv(ctx context.Context, com *command) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(traceContext(ctx), carrier)
+	if len(carrier) == 0 {
+		return
+	}
+	if com.Env == nil {
+		com.Env = make(map[string]string)
+	}
+	for key, val := range carrier {
+		com.Env[strings.ToUpper(key)] = val
+	}
+}
+
+func requestTraceAttributes(received *request) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		traceAttrType.String(received.typ),
+		traceAttrHostName.String(received.hostName),
+	}
+	if received.serviceDescription != "" {
+		attributes = append(attributes, traceAttrServiceDescription.String(received.serviceDescription))
+	}
+
+	return attributes
+}
+
+// setSpanResult adds the check result to the span
+func setSpanResult(span trace.Span, result *answer) {
+	if result == nil {
+		return
+	}
+	span.SetAttributes(
+		traceAttrExecType.String(result.execType),
+		traceAttrReturnCode.Int(result.returnCode),
+		traceAttrTimedOut.Bool(result.timedOut),
+	)
+	if result.timedOut {
+		span.SetStatus(codes.Error, "timeout")
+	}
+}
+
+func setSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func traceContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+
+	return ctx
+}
+
+// traceFileClient writes OTLP json encoded trace requests to a file, one request per line
+type traceFileClient struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+func (c *traceFileClient) Start(_ context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	file, err := os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open trace file %s: %w", c.path, err)
+	}
+	c.file = file
+
+	return nil
+}
+
+func (c *traceFileClient) Stop(_ context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+
+	return err
+}
+
+func (c *traceFileClient) UploadTraces(_ context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	data, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return fmt.Errorf("json error: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.file == nil {
+		return fmt.Errorf("trace file %s is not open", c.path)
+	}
+	_, err = c.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot write trace file %s: %w", c.path, err)
+	}
+
+	return nil
+}
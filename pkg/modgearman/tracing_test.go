@@ -0,0 +1,197 @@
+package modgearman
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTraceExporterParse(t *testing.T) {
+	for _, definition := range []string{"http://localhost:4318", "https://localhost/v1/traces", "file:///tmp/traces.jsonl"} {
+		_, _, err := parseTraceExporter(definition)
+		require.NoErrorf(t, err, "parsing %s", definition)
+	}
+	for _, definition := range []string{"/tmp/traces.jsonl", "grpc://localhost:4317", "file://"} {
+		_, _, err := parseTraceExporter(definition)
+		require.Errorf(t, err, "parsing %s", definition)
+	}
+}
+
+func TestTracingHTTP(t *testing.T) {
+	requests := make(chan *coltracepb.ExportTraceServiceRequest, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		assert.NoError(t, proto.Unmarshal(body, req))
+		requests <- req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.identifier = "testworker"
+	cfg.traceExporter = collector.URL
+	initializeTracing(&cfg)
+	defer terminateTracing()
+
+	resultServerQueue = make(chan *answer, 1)
+	defer func() { resultServerQueue = nil }()
+
+	received := &request{
+		typ:                "service",
+		hostName:           "testhost",
+		serviceDescription: "testsvc",
+		commandLine:        `/bin/sh -c 'echo $TRACEPARENT'`,
+		timeout:            10,
+	}
+	span := startJobSpan("H:1", received, time.Now(), nil)
+	result := readAndExecute(received, &cfg)
+	result.traceCtx = received.traceCtx
+	enqueueServerResult(result)
+	span.End()
+	terminateTracing()
+
+	spans := map[string]*tracepb.Span{}
+	for len(requests) > 0 {
+		for _, span := range collectTraceSpans(<-requests) {
+			spans[span.GetName()] = span
+		}
+	}
+	for _, name := range []string{"doWork", "decryptJobData", "readAndExecute", "executeCommandLine", "execCmd", "enqueueServerResult"} {
+		require.Containsf(t, spans, name, "span %s exported", name)
+	}
+
+	root := spans["doWork"]
+	assert.Empty(t, root.GetParentSpanId())
+	assert.Equal(t, root.GetSpanId(), spans["readAndExecute"].GetParentSpanId())
+	assert.Equal(t, spans["readAndExecute"].GetSpanId(), spans["executeCommandLine"].GetParentSpanId())
+	assert.Equal(t, spans["executeCommandLine"].GetSpanId(), spans["execCmd"].GetParentSpanId())
+	assert.Equal(t, root.GetSpanId(), spans["enqueueServerResult"].GetParentSpanId())
+
+	attributes := traceSpanAttributes(root)
+	assert.Equal(t, "testhost", attributes["modgearman.host_name"])
+	assert.Equal(t, "testsvc", attributes["modgearman.service_description"])
+	assert.Equal(t, "H:1", attributes["modgearman.job.handle"])
+	assert.Equal(t, "sh echo $TRACEPARENT", traceSpanAttributes(spans["execCmd"])["modgearman.command"])
+
+	// plugin receives the context of the exec span
+	traceParent := strings.Split(result.output, "-")
+	require.Lenf(t, traceParent, 4, "traceparent: %s", result.output)
+	assert.Equal(t, "00", traceParent[0])
+	assert.Equal(t, root.GetTraceId(), mustDecodeHex(t, traceParent[1]))
+	assert.Equal(t, spans["execCmd"].GetSpanId(), mustDecodeHex(t, traceParent[2]))
+}
+
+func TestTracingFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.traceExporter = "file://" + file
+	initializeTracing(&cfg)
+	defer terminateTracing()
+
+	received, err := createReceived([]byte("type=host\nhost_name=testhost\n" +
+		"traceparent=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01\n"))
+	require.NoError(t, err)
+	span := startJobSpan("H:2", received, time.Now(), nil)
+	span.End()
+	terminateTracing()
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+
+	req := &coltracepb.ExportTraceServiceRequest{}
+	require.NoError(t, protojson.Unmarshal([]byte(lines[0]), req))
+	spans := collectTraceSpans(req)
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, mustDecodeHex(t, "0af7651916cd43dd8448eb211c80319c"), span.GetTraceId())
+		if span.GetName() == "doWork" {
+			assert.Equal(t, mustDecodeHex(t, "b7ad6b7169203331"), span.GetParentSpanId())
+		}
+	}
+}
+
+func TestTracingReload(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.traceExporter = "file://" + filepath.Join(t.TempDir(), "traces.jsonl")
+	initializeTracing(&cfg)
+	defer terminateTracing()
+
+	// jobs keep creating spans while tracing gets reloaded
+	done := make(chan bool)
+	go func() {
+		for range 100 {
+			received := &request{typ: "host", hostName: "testhost"}
+			span := startJobSpan("H:3", received, time.Now(), nil)
+			_, end := startRequestSpan(received, "readAndExecute")
+			end()
+			span.End()
+		}
+		done <- true
+	}()
+	for range 5 {
+		terminateTracing()
+		initializeTracing(&cfg)
+	}
+	<-done
+	require.NotNil(t, currentTracing.Load())
+}
+
+func TestTracingDisabledPropagatesParent(t *testing.T) {
+	received := &request{
+		traceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		commandLine: "/bin/true",
+	}
+	span := startJobSpan("H:3", received, time.Now(), nil)
+	defer span.End()
+
+	com := parseCommand(received.commandLine, &config{})
+	injectTraceEnv(received.traceCtx, com)
+	assert.Equal(t, received.traceParent, com.Env["TRACEPARENT"])
+}
+
+func collectTraceSpans(req *coltracepb.ExportTraceServiceRequest) (spans []*tracepb.Span) {
+	for _, resourceSpans := range req.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			spans = append(spans, scopeSpans.GetSpans()...)
+		}
+	}
+
+	return spans
+}
+
+func traceSpanAttributes(span *tracepb.Span) map[string]string {
+	attributes := map[string]string{}
+	for _, attr := range span.GetAttributes() {
+		attributes[attr.GetKey()] = attr.GetValue().GetStringValue()
+	}
+
+	return attributes
+}
+
+func mustDecodeHex(t *testing.T, input string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(input)
+	require.NoError(t, err)
+
+	return data
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	libworker "github.com/appscode/g2/worker"
+	"github.com/kdar/factorlog"
 )
 
 type worker struct {
@@ -17,22 +18,26 @@ type worker struct {
 	activeJobs int
 	config     *config
 	mainWorker *mainWorker
+	pool       *workerPool
 	jobs       []*request
+	stopped    bool // set once Shutdown has been called
 	lock       sync.RWMutex
 }
 
 // creates a new worker and returns a pointer to it
-func newWorker(what string, configuration *config, mainWorker *mainWorker) *worker {
+func newWorker(what string, pool *workerPool, configuration *config, mainWorker *mainWorker) *worker {
 	log.Tracef("starting new %sworker", what)
 	worker := &worker{
 		what:       what,
 		activeJobs: 0,
 		config:     configuration,
 		mainWorker: mainWorker,
+		pool:       pool,
 	}
 	worker.id = fmt.Sprintf("%p", worker)
 
-	wrk := libworker.New(libworker.OneByOne)
+	// each connection runs up to jobs_per_connection jobs concurrently
+	wrk := libworker.New(worker.jobsPerConnection())
 	worker.worker = wrk
 
 	wrk.ErrorHandler = func(e error) {
@@ -81,31 +86,8 @@ func (worker *worker) registerFunctions(configuration *config) {
 	// specifies what events the worker listens
 	switch worker.what {
 	case "check":
-		if worker.config.eventhandler {
-			logError(wrk.AddFunc("eventhandler", worker.doWork, libworker.Unlimited))
-		}
-		if worker.config.hosts {
-			logError(wrk.AddFunc("host", worker.doWork, libworker.Unlimited))
-		}
-		if worker.config.services {
-			logError(wrk.AddFunc("service", worker.doWork, libworker.Unlimited))
-		}
-		if worker.config.notifications {
-			logError(wrk.AddFunc("notification", worker.doWork, libworker.Unlimited))
-		}
-
-		// register for the hostgroups
-		if len(worker.config.hostgroups) > 0 {
-			for _, element := range worker.config.hostgroups {
-				logError(wrk.AddFunc("hostgroup_"+element, worker.doWork, libworker.Unlimited))
-			}
-		}
-
-		// register for servicegroups
-		if len(worker.config.servicegroups) > 0 {
-			for _, element := range worker.config.servicegroups {
-				logError(wrk.AddFunc("servicegroup_"+element, worker.doWork, libworker.Unlimited))
-			}
+		for _, queue := range worker.listenQueues() {
+			logError(wrk.AddFunc(queue, worker.doWork, libworker.Unlimited))
 		}
 	case "status":
 		statusQueue := fmt.Sprintf("worker_%s", configuration.identifier)
@@ -115,32 +97,88 @@ func (worker *worker) registerFunctions(configuration *config) {
 	}
 }
 
+// listenQueues returns the queues of the worker pool
+func (worker *worker) listenQueues() []string {
+	if worker.pool != nil {
+		return worker.pool.queues
+	}
+
+	return worker.config.sharedQueues()
+}
+
+// jobsPerConnection returns the number of jobs this worker runs concurrently
+func (worker *worker) jobsPerConnection() int {
+	if worker.pool == nil || worker.pool.jobsPerConnection < 1 {
+		return libworker.OneByOne
+	}
+
+	return worker.pool.jobsPerConnection
+}
+
+// changeActiveJobs adjusts the number of running jobs
+func (worker *worker) changeActiveJobs(delta int) {
+	worker.lock.Lock()
+	worker.activeJobs += delta
+	worker.lock.Unlock()
+}
+
+// numActiveJobs returns the number of running jobs
+func (worker *worker) numActiveJobs() int {
+	worker.lock.RLock()
+	defer worker.lock.RUnlock()
+
+	return worker.activeJobs
+}
+
+// isBusy returns true if the worker cannot take any more jobs
+func (worker *worker) isBusy() bool {
+	return worker.numActiveJobs() >= worker.jobsPerConnection()
+}
+
+// poolName returns the name of the worker pool
+func (worker *worker) poolName() string {
+	if worker.pool == nil {
+		return defaultWorkerPool
+	}
+
+	return worker.pool.name
+}
+
 func (worker *worker) doWork(job libworker.Job) (res []byte, err error) {
 	defer logPanicExit()
 
 	res = []byte("OK")
 	log.Tracef("worker got a job: %s", job.Handle())
 
-	worker.activeJobs++
+	worker.changeActiveJobs(1)
+	start := time.Now()
 	received, err := decryptJobData(job.Data(), worker.config.encryption)
+	span := startJobSpan(job.Handle(), received, start, err)
 	if err != nil {
 		log.Errorf("decrypt failed: %w", err)
-		worker.activeJobs--
+		worker.changeActiveJobs(-1)
+		span.End()
 
 		return nil, err
 	}
 
-	worker.mainWorker.tasks++
+	received.receivedAt = start
+	received.handle = job.Handle()
+	received.pool = worker.poolName()
+	received.queue = job.Fn()
+	worker.mainWorker.tasks.Add(1)
 	logJob(job, received, "incoming", nil)
 	log.Trace(received)
 
 	worker.addJob(received)
-	defer worker.removeJob(received)
 
 	if !worker.considerballooning() {
 		answer := worker.executeJob(received)
-		worker.activeJobs--
-		if received.Canceled {
+		setSpanResult(span, answer)
+		span.End()
+		worker.removeJob(received)
+		worker.changeActiveJobs(-1)
+		if received.isCanceled() {
 			logJob(job, received, "canceled", answer)
 			res = make([]byte, 0)
 
@@ -151,19 +189,28 @@ func (worker *worker) doWork(job libworker.Job) (res []byte, err error) {
 		return res, nil
 	}
 
+	// finished and received.ballooning are guarded by the worker lock
+	finished := false
 	finChan := make(chan bool, 1)
 	go func() {
 		defer logPanicExit()
 		defer func() {
-			worker.activeJobs--
-			if received.ballooning {
-				worker.mainWorker.curBallooningWorker--
-				ballooningWorkerCount.Set(float64(worker.mainWorker.curBallooningWorker))
+			// ballooned jobs are removed once they are really finished, not when doWork returns
+			worker.removeJob(received)
+			worker.changeActiveJobs(-1)
+			worker.lock.Lock()
+			finished = true
+			ballooning := received.ballooning
+			worker.lock.Unlock()
+			if ballooning {
+				worker.mainWorker.changeBallooningWorker(-1)
 			}
 			finChan <- true
 		}()
 		answer := worker.executeJob(received)
-		if received.Canceled {
+		setSpanResult(span, answer)
+		span.End()
+		if received.isCanceled() {
 			logJob(job, received, "canceled", answer)
 		} else {
 			logJob(job, received, "finished", answer)
@@ -178,15 +225,20 @@ func (worker *worker) doWork(job libworker.Job) (res []byte, err error) {
 			return res, nil
 		case <-ticker.C:
 			// check again if are there open files left for ballooning
-			if worker.startballooning() {
+			if !worker.startballooning() {
+				continue
+			}
+			worker.lock.Lock()
+			received.ballooning = !finished
+			ballooning := received.ballooning
+			worker.lock.Unlock()
+			if ballooning {
 				log.Debugf("job: %s runs for more than %d seconds, backgrounding...",
 					job.Handle(), worker.config.backgroundingThreshold)
-				worker.mainWorker.curBallooningWorker++
-				ballooningWorkerCount.Set(float64(worker.mainWorker.curBallooningWorker))
-				received.ballooning = true
-
-				return res, nil
+				worker.mainWorker.changeBallooningWorker(1)
 			}
+
+			return res, nil
 		}
 	}
 }
@@ -197,8 +249,13 @@ func (worker *worker) considerballooning() bool {
 		return false
 	}
 
+	// backgrounded jobs would exceed the queue limit
+	if worker.pool != nil && worker.pool.noBallooning {
+		return false
+	}
+
 	// only if 70% of our workers are utilized
-	if worker.mainWorker.workerUtilization < ballooningUtilizationThreshold {
+	if worker.mainWorker.workerUtilization.Load() < ballooningUtilizationThreshold {
 		return false
 	}
 
@@ -222,30 +279,42 @@ func (worker *worker) startballooning() bool {
 		return false
 	}
 
+	passed, _ = worker.mainWorker.checkPressure()
+	if !passed {
+		return false
+	}
+
 	// only if 70% of our workers are utilized
-	if worker.mainWorker.workerUtilization < ballooningUtilizationThreshold {
+	if worker.mainWorker.workerUtilization.Load() < ballooningUtilizationThreshold {
 		return false
 	}
 
 	// are there open files left for ballooning
-	if worker.mainWorker.curBallooningWorker >= (worker.mainWorker.maxPossibleWorker - worker.config.maxWorker) {
+	curBallooningWorker := worker.mainWorker.numBallooningWorker()
+	maxBallooningWorker := worker.mainWorker.maxPossibleWorker - worker.config.totalMaxWorker()
+	if curBallooningWorker >= maxBallooningWorker {
 		return false
 	}
 
-	log.Debugf("ballooning: cur: %d max: %d",
-		worker.mainWorker.curBallooningWorker, (worker.mainWorker.maxPossibleWorker - worker.config.maxWorker))
+	log.Debugf("ballooning: cur: %d max: %d", curBallooningWorker, maxBallooningWorker)
 
 	return true
 }
 
 // executeJob executes the job and handles sending the result
 func (worker *worker) executeJob(received *request) *answer {
+	if received.timeout <= 0 && worker.pool != nil {
+		received.timeout = worker.pool.jobTimeout
+	}
 	result := readAndExecute(received, worker.config)
+	result.traceCtx = received.traceCtx
 
-	if !received.Canceled && received.resultQueue != "" {
+	if !received.isCanceled() && received.resultQueue != "" {
 		log.Tracef("result:\n%s", result)
 		enqueueServerResult(result)
 		enqueueDupServerResult(worker.config, result)
+		enqueueResultSinks(result)
+		enqueuePerfdataForwarders(result)
 	}
 
 	return result
@@ -265,66 +334,104 @@ func (worker *worker) errorHandler(err error) {
 	worker.Shutdown()
 }
 
-// Shutdown and deregister this worker
+// Shutdown and deregister this worker, it is safe to call Shutdown multiple times and
+// concurrently. Only the first call stops the worker, all others return immediately.
 func (worker *worker) Shutdown() {
+	worker.lock.Lock()
+	if worker.stopped {
+		worker.lock.Unlock()
+
+		return
+	}
+	worker.stopped = true
+	wrk := worker.worker
+	worker.worker = nil
+	worker.lock.Unlock()
+
 	log.Debugf("worker shutting down")
 	defer func() {
 		if worker.mainWorker != nil && worker.mainWorker.running {
 			worker.mainWorker.unregisterWorker(worker)
 		}
 	}()
-	if worker.worker != nil {
-		worker.worker.ErrorHandler = nil
-		if worker.activeJobs > 0 {
+	if wrk != nil {
+		wrk.ErrorHandler = nil
+		if worker.numActiveJobs() > 0 {
 			// try to stop gracefully
-			worker.worker.Shutdown()
-		}
-		if worker.worker != nil {
-			worker.worker.Close()
+			wrk.Shutdown()
 		}
+		wrk.Close()
 	}
-	worker.worker = nil
 }
 
 // Cancel current job(s)
 func (worker *worker) Cancel() {
-	if worker.activeJobs == 0 {
+	if worker.numActiveJobs() == 0 {
 		return
 	}
 	log.Debugf("worker %s cancling current jobs", worker.id)
 	worker.lock.Lock()
 	for _, j := range worker.jobs {
-		if j.Cancel == nil {
-			continue
-		}
-		j.Cancel()
-	}
-	if worker.worker != nil {
-		worker.worker.Close()
+		j.Cancel(false)
 	}
+	wrk := worker.worker
 	worker.lock.Unlock()
+	if wrk != nil {
+		wrk.Close()
+	}
 }
 
 func logJob(job libworker.Job, received *request, prefix string, result *answer) {
+	fields := logFields{
+		"handle": job.Handle(),
+		"type":   received.typ,
+	}
+	if received.hostName != "" {
+		fields["host_name"] = received.hostName
+	}
+	if received.serviceDescription != "" {
+		fields["service_description"] = received.serviceDescription
+	}
 	suffix := ""
 	if result != nil {
 		suffix = fmt.Sprintf(" (took: %.3fs | rc: %d | exec: %s)",
 			result.finishTime-result.startTime, result.returnCode, result.execType)
+		fields["exec_type"] = result.execType
+		fields["return_code"] = result.returnCode
+		fields["duration"] = result.finishTime - result.startTime
 	}
 	switch {
 	case received.serviceDescription != "":
-		log.Debugf("%s %-7s - handle: %s - host: %20s - service: %s%s",
+		logWithFields(factorlog.DEBUG, fields, "%s %-7s - handle: %s - host: %20s - service: %s%s",
 			prefix, received.typ, job.Handle(), received.hostName, received.serviceDescription, suffix)
 	case received.hostName != "":
-		log.Debugf("%s %-7s - handle: %s - host: %20s%s", prefix, received.typ, job.Handle(), received.hostName, suffix)
+		logWithFields(factorlog.DEBUG, fields, "%s %-7s - handle: %s - host: %20s%s", prefix, received.typ, job.Handle(), received.hostName, suffix)
 	default:
-		log.Debugf("%s %-7s - handle: %s%s", prefix, received.typ, job.Handle(), suffix)
+		logWithFields(factorlog.DEBUG, fields, "%s %-7s - handle: %s%s", prefix, received.typ, job.Handle(), suffix)
 		if result != nil && log.IsV(2) {
 			log.Tracef("Output:\n%s", result.output)
 		}
 	}
 }
 
+// cancelJobs cancels all running jobs matching the filter. Canceled jobs
+// send back a result with the cancel_return code.
+func (worker *worker) cancelJobs(match func(*request) bool) (matched []*request) {
+	worker.lock.Lock()
+	defer worker.lock.Unlock()
+	for _, j := range worker.jobs {
+		if !match(j) {
+			continue
+		}
+		matched = append(matched, j)
+		if j.Cancel(true) {
+			log.Infof("canceled job %s - host: %s - service: %s", j.handle, j.hostName, j.serviceDescription)
+		}
+	}
+
+	return matched
+}
+
 func (worker *worker) addJob(received *request) {
 	worker.lock.Lock()
 	worker.jobs = append(worker.jobs, received)
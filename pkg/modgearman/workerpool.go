@@ -0,0 +1,347 @@
+package modgearman
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// defaultWorkerPool is the name of the pool serving all queues not assigned to other pools
+	defaultWorkerPool = "default"
+
+	// namedPoolMinWorker sets the min-worker of named pools, the global min-worker applies to the default pool only
+	namedPoolMinWorker = 1
+)
+
+// workerPool is a group of check worker sharing the same queues and limits
+type workerPool struct {
+	name              string
+	queues            []string
+	minWorker         int
+	maxWorker         int
+	spawnRate         int
+	sinkRate          int
+	jobTimeout        int
+	jobsPerConnection int
+	loadLimit1        float64
+	loadLimit5        float64
+	loadLimit15       float64
+	noBallooning      bool
+}
+
+// newWorkerPool creates a pool which inherits all limits from the global config
+func newWorkerPool(name string, config *config) *workerPool {
+	return &workerPool{
+		name:              name,
+		minWorker:         config.minWorker,
+		maxWorker:         config.maxWorker,
+		spawnRate:         config.spawnRate,
+		sinkRate:          config.sinkRate,
+		jobTimeout:        config.jobTimeout,
+		jobsPerConnection: config.jobsPerConnection,
+		loadLimit1:        config.loadLimit1,
+		loadLimit5:        config.loadLimit5,
+		loadLimit15:       config.loadLimit15,
+	}
+}
+
+// parseWorkerPools parses the named pools from pool.<name>.<option>=<value> settings
+func parseWorkerPools(config *config) ([]*workerPool, error) {
+	pools := []*workerPool{}
+	byName := map[string]*workerPool{}
+	for _, setting := range config.workerPoolSettings {
+		key, value, _ := strings.Cut(setting, "=")
+		name, option, ok := strings.Cut(key, ".")
+		if !ok || name == "" || option == "" {
+			return nil, fmt.Errorf("invalid worker pool setting pool.%s, expected pool.<name>.<option>=<value>", setting)
+		}
+		if name == defaultWorkerPool {
+			return nil, fmt.Errorf("worker pool name %s is reserved", defaultWorkerPool)
+		}
+
+		pool, ok := byName[name]
+		if !ok {
+			pool = newWorkerPool(name, config)
+			pool.minWorker = namedPoolMinWorker
+			byName[name] = pool
+			pools = append(pools, pool)
+		}
+
+		switch option {
+		case "queues":
+			for _, queue := range strings.Split(value, ",") {
+				queue = strings.TrimSpace(queue)
+				if queue != "" {
+					pool.queues = append(pool.queues, queue)
+				}
+			}
+		case "min-worker":
+			pool.minWorker = getInt(value)
+		case "max-worker":
+			pool.maxWorker = getInt(value)
+		case "spawn-rate":
+			pool.spawnRate = getInt(value)
+		case "sink-rate":
+			pool.sinkRate = getInt(value)
+		case "job_timeout":
+			pool.jobTimeout = getInt(value)
+		case "jobs_per_connection":
+			pool.jobsPerConnection = getInt(value)
+		case "load_limit1":
+			pool.loadLimit1 = getFloat(value)
+		case "load_limit5":
+			pool.loadLimit5 = getFloat(value)
+		case "load_limit15":
+			pool.loadLimit15 = getFloat(value)
+		default:
+			return nil, fmt.Errorf("unknown worker pool option %s in pool.%s", option, setting)
+		}
+	}
+
+	for _, pool := range pools {
+		if len(pool.queues) == 0 {
+			return nil, fmt.Errorf("worker pool %s has no queues", pool.name)
+		}
+		if pool.minWorker > pool.maxWorker {
+			pool.maxWorker = pool.minWorker
+		}
+		if pool.jobsPerConnection < 1 {
+			return nil, fmt.Errorf("worker pool %s: jobs_per_connection must be at least 1", pool.name)
+		}
+	}
+
+	return pools, nil
+}
+
+// workerPools returns the default pool with all remaining queues, followed
+// by the named pools and one pool for each queue limit.
+func (config *config) workerPools() []*workerPool {
+	return config.pools
+}
+
+// checkQueues returns all queues served by the check worker
+func (config *config) checkQueues() (queues []string) {
+	if config.eventhandler {
+		queues = append(queues, "eventhandler")
+	}
+	if config.hosts {
+		queues = append(queues, "host")
+	}
+	if config.services {
+		queues = append(queues, "service")
+	}
+	if config.notifications {
+		queues = append(queues, "notification")
+	}
+	for _, element := range config.hostgroups {
+		queues = append(queues, "hostgroup_"+element)
+	}
+	for _, element := range config.servicegroups {
+		queues = append(queues, "servicegroup_"+element)
+	}
+
+	return queues
+}
+
+// sharedQueues returns all queues served by the default pool, which are all
+// queues except those of named pools and those with a maximum queue limit.
+func (config *config) sharedQueues() []string {
+	for _, pool := range config.pools {
+		if pool.name == defaultWorkerPool {
+			return pool.queues
+		}
+	}
+
+	return nil
+}
+
+// maxJobsPerConnection returns the highest jobs_per_connection of all pools
+func (config *config) maxJobsPerConnection() int {
+	maxJobs := max(config.jobsPerConnection, 1)
+	for _, pool := range config.pools {
+		maxJobs = max(maxJobs, pool.jobsPerConnection)
+	}
+
+	return maxJobs
+}
+
+// totalMaxWorker returns the maximum number of check worker summed up over all pools
+func (config *config) totalMaxWorker() int {
+	if len(config.pools) == 0 {
+		return config.maxWorker
+	}
+	total := 0
+	for _, pool := range config.pools {
+		total += pool.maxWorker
+	}
+
+	return total
+}
+
+// limitMaxWorker reduces the max-worker of all pools proportionally, so the total
+// does not exceed the given limit. Each pool keeps at least one worker.
+func (config *config) limitMaxWorker(limit int) {
+	total := config.totalMaxWorker()
+	if total <= limit || total <= 0 {
+		return
+	}
+	for _, pool := range config.pools {
+		pool.maxWorker = max(pool.maxWorker*limit/total, 1)
+		pool.minWorker = min(pool.minWorker, pool.maxWorker)
+	}
+	config.maxWorker = max(config.maxWorker*limit/total, 1)
+	config.minWorker = min(config.minWorker, config.maxWorker)
+}
+
+// hasLoadLimits returns true if any load limit is set globally or for a pool
+func (config *config) hasLoadLimits() bool {
+	if config.loadLimit1 > 0 || config.loadLimit5 > 0 || config.loadLimit15 > 0 {
+		return true
+	}
+	for _, pool := range config.pools {
+		if pool.loadLimit1 > 0 || pool.loadLimit5 > 0 || pool.loadLimit15 > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkWorkerPools verifies all pools and queue limits refer to enabled queues
+// and each queue is assigned to one pool at most. The resulting pools are stored
+// in the config.
+func checkWorkerPools(config *config) error {
+	config.pools = nil
+	enabled := map[string]bool{}
+	for _, queue := range config.checkQueues() {
+		enabled[queue] = true
+	}
+
+	pools, err := parseWorkerPools(config)
+	if err != nil {
+		return err
+	}
+	assigned := map[string]string{}
+	for _, pool := range pools {
+		for _, queue := range pool.queues {
+			if !enabled[queue] {
+				return fmt.Errorf("worker pool %s refers to queue %s which is not enabled", pool.name, queue)
+			}
+			if other, ok := assigned[queue]; ok {
+				return fmt.Errorf("queue %s is used in worker pool %s and %s", queue, other, pool.name)
+			}
+			assigned[queue] = pool.name
+		}
+	}
+
+	limits := []*queueLimit{}
+	seen := map[string]bool{}
+	for _, definition := range config.queueLimits {
+		limit, err := parseQueueLimit(definition)
+		if err != nil {
+			return err
+		}
+		if !enabled[limit.queue] {
+			return fmt.Errorf("queue_limit %s refers to a queue which is not enabled", definition)
+		}
+		if pool, ok := assigned[limit.queue]; ok {
+			return fmt.Errorf("queue_limit %s refers to a queue of worker pool %s", definition, pool)
+		}
+		if seen[limit.queue] {
+			return fmt.Errorf("duplicate queue_limit for queue %s", limit.queue)
+		}
+		seen[limit.queue] = true
+		limits = append(limits, limit)
+		if limit.maxWorker > 0 {
+			assigned[limit.queue] = "queue:" + limit.queue
+		}
+	}
+
+	// the default pool serves all queues which are not assigned to other pools
+	shared := []string{}
+	for _, queue := range config.checkQueues() {
+		if _, ok := assigned[queue]; !ok {
+			shared = append(shared, queue)
+		}
+	}
+	if len(shared) > 0 {
+		pool := newWorkerPool(defaultWorkerPool, config)
+		pool.queues = shared
+		config.pools = append(config.pools, pool)
+	}
+	config.pools = append(config.pools, pools...)
+	for _, limit := range limits {
+		config.pools = append(config.pools, limit.pool(config))
+	}
+
+	return nil
+}
+
+// managePool starts and stops the worker of a single pool
+func (w *mainWorker) managePool(pool *workerPool, initialStart int) (reason string) {
+	total, active := w.countWorker(pool.name)
+	poolWorkerCount.WithLabelValues(pool.name).Set(float64(total))
+	poolWorkingWorkerCount.WithLabelValues(pool.name).Set(float64(active))
+	poolIdleWorkerCount.WithLabelValues(pool.name).Set(float64(total - active))
+
+	// as long as there are to few workers start them without a limit
+	minWorker := pool.minWorker
+	if initialStart > 0 {
+		minWorker = initialStart
+	}
+	log.Tracef("manageWorkers: pool: %s, total: %d, active: %d, minWorker: %d", pool.name, total, active, minWorker)
+	for i := minWorker - total; i > 0; i-- {
+		log.Tracef("manageWorkers: starting minworker for pool %s: %d, %d", pool.name, minWorker-total, i)
+		worker := newWorker("check", pool, w.cfg, w)
+		w.registerWorker(worker)
+		w.resetIdleSince()
+		countScalingDecision(pool, scalingActionStart, scalingReasonMinWorker, 1)
+	}
+
+	// check if we have too many workers
+	w.adjustWorkerBottomLevel(pool)
+
+	// check if we need more workers
+	return w.adjustWorkerTopLevel(pool)
+}
+
+// countWorker returns the number of total and busy check worker of the given pool,
+// a worker is busy if all its jobs_per_connection slots are in use
+func (w *mainWorker) countWorker(pool string) (total, active int) {
+	w.workerMapLock.RLock()
+	defer w.workerMapLock.RUnlock()
+
+	for _, wrk := range w.workerMap {
+		if wrk.poolName() != pool {
+			continue
+		}
+		total++
+		if wrk.isBusy() {
+			active++
+		}
+	}
+
+	return total, active
+}
+
+// stopIdleWorker stops the first idle worker of the given pool and returns true if one has been stopped
+func (w *mainWorker) stopIdleWorker(pool string) bool {
+	w.workerMapLock.RLock()
+	var idle *worker
+	for _, wrk := range w.workerMap {
+		if wrk.poolName() == pool && wrk.numActiveJobs() == 0 {
+			idle = wrk
+
+			break
+		}
+	}
+	w.workerMapLock.RUnlock()
+
+	if idle == nil {
+		return false
+	}
+	log.Debugf("manageWorkers: stopping one...")
+	idle.Shutdown()
+
+	return true
+}
@@ -0,0 +1,140 @@
+package modgearman
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolConfig(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	cfg.loadLimit1 = 10
+	for _, item := range []string{
+		"hosts=yes",
+		"services=yes",
+		"eventhandler=yes",
+		"notifications=yes",
+		"pool.alerts.queues=notification, eventhandler",
+		"pool.alerts.min-worker=2",
+		"pool.alerts.max-worker=5",
+		"pool.alerts.job_timeout=30",
+		"pool.alerts.load_limit1=50",
+	} {
+		require.NoError(t, cfg.parseConfigItem(item))
+	}
+	require.NoError(t, checkWorkerPools(&cfg))
+
+	pools := cfg.workerPools()
+	require.Len(t, pools, 2)
+	assert.Equal(t, "default", pools[0].name)
+	assert.Equal(t, []string{"host", "service"}, pools[0].queues)
+	assert.Equal(t, cfg.minWorker, pools[0].minWorker)
+	assert.InDelta(t, 10, pools[0].loadLimit1, 0)
+
+	alerts := pools[1]
+	assert.Equal(t, "alerts", alerts.name)
+	assert.Equal(t, []string{"notification", "eventhandler"}, alerts.queues)
+	assert.Equal(t, 2, alerts.minWorker)
+	assert.Equal(t, 5, alerts.maxWorker)
+	assert.Equal(t, 30, alerts.jobTimeout)
+	assert.Equal(t, cfg.spawnRate, alerts.spawnRate)
+	assert.InDelta(t, 50, alerts.loadLimit1, 0)
+
+	// job timeout from pool is used unless the job has its own timeout
+	wrk := &worker{config: &cfg, pool: alerts}
+	received := &request{typ: "notification", commandLine: "/bin/true"}
+	wrk.executeJob(received)
+	assert.Equal(t, 30, received.timeout)
+
+	// queue limit on a queue of a named pool
+	cfg.queueLimits = []string{"notification:1"}
+	require.Error(t, checkWorkerPools(&cfg))
+	cfg.queueLimits = nil
+
+	// queue used in two pools
+	require.NoError(t, cfg.parseConfigItem("pool.other.queues=host,notification"))
+	require.Error(t, checkWorkerPools(&cfg))
+}
+
+func TestWorkerPoolConfigErrors(t *testing.T) {
+	for _, settings := range [][]string{
+		{"default.queues=host"},
+		{"empty.min-worker=1"},
+		{"broken.unknown=1", "broken.queues=host"},
+		{"missing.queues=hostgroup_none"},
+	} {
+		cfg := config{}
+		cfg.setDefaultValues()
+		cfg.hosts = true
+		cfg.workerPoolSettings = settings
+		require.Errorf(t, checkWorkerPools(&cfg), "settings: %v", settings)
+	}
+}
+
+func TestWorkerPoolJobsPerConnection(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	for _, item := range []string{
+		"hosts=yes",
+		"services=yes",
+		"hostgroups=slow",
+		"min-worker=5",
+		"jobs_per_connection=10",
+		"pool.bulk.queues=service",
+		"pool.bulk.jobs_per_connection=25",
+		"queue_limit=hostgroup_slow:0:5",
+	} {
+		require.NoError(t, cfg.parseConfigItem(item))
+	}
+	require.NoError(t, checkWorkerPools(&cfg))
+
+	pools := cfg.workerPools()
+	require.Len(t, pools, 3)
+	assert.Equal(t, 10, pools[0].jobsPerConnection)
+	assert.Equal(t, 25, pools[1].jobsPerConnection)
+	assert.Equal(t, 1, pools[1].minWorker, "named pools do not inherit the global min-worker")
+	assert.Equal(t, 1, pools[2].jobsPerConnection, "capped queues run one job per connection")
+	assert.Equal(t, 25, cfg.maxJobsPerConnection())
+
+	// a worker is busy once all slots are in use
+	wrk := &worker{config: &cfg, pool: pools[0]}
+	assert.Equal(t, 10, wrk.jobsPerConnection())
+	wrk.changeActiveJobs(9)
+	assert.False(t, wrk.isBusy())
+	wrk.changeActiveJobs(1)
+	assert.True(t, wrk.isBusy())
+	assert.Equal(t, 1, (&worker{config: &cfg}).jobsPerConnection())
+
+	require.NoError(t, cfg.parseConfigItem("pool.bulk.jobs_per_connection=0"))
+	require.Error(t, checkWorkerPools(&cfg))
+}
+
+func TestWorkerPoolMaxWorkerBudget(t *testing.T) {
+	cfg := config{}
+	cfg.setDefaultValues()
+	for _, item := range []string{
+		"hosts=yes",
+		"services=yes",
+		"notifications=yes",
+		"max-worker=40",
+		"pool.alerts.queues=notification",
+		"queue_limit=host:0:10",
+	} {
+		require.NoError(t, cfg.parseConfigItem(item))
+	}
+	require.NoError(t, checkWorkerPools(&cfg))
+
+	// named pools inherit the global max-worker
+	assert.Equal(t, 90, cfg.totalMaxWorker())
+
+	cfg.limitMaxWorker(45)
+	pools := cfg.workerPools()
+	require.Len(t, pools, 3)
+	assert.Equal(t, 20, pools[0].maxWorker)
+	assert.Equal(t, 20, pools[1].maxWorker)
+	assert.Equal(t, 5, pools[2].maxWorker)
+	assert.Equal(t, 45, cfg.totalMaxWorker())
+	assert.Equal(t, 20, cfg.maxWorker)
+}